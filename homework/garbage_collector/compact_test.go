@@ -0,0 +1,242 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type PhaseStats struct {
+	Pause   time.Duration
+	Objects int // marked, freed or moved objects
+	Bytes   int // marked, reclaimed or moved bytes
+}
+
+type CycleStats struct {
+	Mark    PhaseStats
+	Sweep   PhaseStats
+	Compact PhaseStats
+}
+
+func (s CycleStats) Pause() time.Duration {
+	return s.Mark.Pause + s.Sweep.Pause + s.Compact.Pause
+}
+
+// Collect runs a stop-the-world mark-sweep-compact cycle. Compaction
+// works like Defragment from homework/allocator, but objects have
+// different sizes and pointers to moved objects are rewritten in the
+// stacks and in the heap. Stacks are changed in place, so unlike Trace
+// every word pointing into the heap must be a real pointer: a conservative
+// collector would have to pin such objects instead of moving them
+func (h *Heap) Collect(stacks [][]uintptr) CycleStats {
+	var stats CycleStats
+
+	start := time.Now()
+	h.Trace(stacks)
+	for _, object := range h.objects {
+		if object.marked {
+			stats.Mark.Objects++
+			stats.Mark.Bytes += object.header.Size * wordSize
+		}
+	}
+	stats.Mark.Pause = time.Since(start)
+
+	start = time.Now()
+	for _, object := range h.sweep() {
+		stats.Sweep.Objects++
+		stats.Sweep.Bytes += object.header.Size * wordSize
+	}
+	stats.Sweep.Pause = time.Since(start)
+
+	start = time.Now()
+	stats.Compact.Objects, stats.Compact.Bytes = h.compact(stacks)
+	stats.Compact.Pause = time.Since(start)
+
+	return stats
+}
+
+// sweep removes unmarked objects and returns them
+func (h *Heap) sweep() []*object {
+	var swept []*object
+	live := h.objects[:0]
+	for _, object := range h.objects {
+		if object.marked {
+			live = append(live, object)
+		} else {
+			swept = append(swept, object)
+		}
+	}
+
+	clear(h.objects[len(live):])
+	h.objects = live
+	return swept
+}
+
+// compact slides live objects down to the start of the heap in three
+// passes: compute forwarding addresses, rewrite pointers, move objects
+func (h *Heap) compact(stacks [][]uintptr) (int, int) {
+	forwarding := make(map[uintptr]uintptr, len(h.objects)) // old address -> new address
+	free := heapBase
+	for _, object := range h.objects {
+		forwarding[object.address] = free
+		free += uintptr(object.header.Size * wordSize)
+	}
+
+	// interior pointers keep their offsets inside objects
+	forward := func(ptr uintptr) uintptr {
+		object := h.find(ptr)
+		if object == nil {
+			return ptr
+		}
+
+		return forwarding[object.address] + (ptr - object.address)
+	}
+
+	for _, stack := range stacks {
+		for idx, ptr := range stack {
+			stack[idx] = forward(ptr)
+		}
+	}
+
+	for _, object := range h.objects {
+		start := h.index(object.address)
+		for slot := 0; slot < object.header.Size; slot++ {
+			if object.header.Pointers.IsPointer(slot) {
+				h.memory[start+slot] = forward(h.memory[start+slot])
+			}
+		}
+	}
+
+	moved, movedBytes := 0, 0
+	used := 0
+	for _, object := range h.objects {
+		from := h.index(object.address)
+		size := object.header.Size
+
+		if from != used {
+			copy(h.memory[used:used+size], h.memory[from:from+size])
+			moved++
+			movedBytes += size * wordSize
+		}
+
+		object.address = h.address(used)
+		used += size
+	}
+
+	clear(h.memory[used:h.used])
+	h.used = used
+	return moved, movedBytes
+}
+
+func TestCollect(t *testing.T) {
+	heap := NewHeap(16)
+
+	// type pair struct { next *int; value int }
+	first := mustAlloc(t, heap, 2, 0)
+	garbage := mustAlloc(t, heap, 3, 0)
+	second := mustAlloc(t, heap, 1)
+	third := mustAlloc(t, heap, 2, 0)
+
+	heap.Store(first, third+wordSize) // interior pointer
+	heap.Store(first+wordSize, 0x10000)
+	heap.Store(garbage, first)
+	heap.Store(second, 42)
+	heap.Store(third, second)
+	heap.Store(third+wordSize, 7)
+
+	stacks := [][]uintptr{{first, 0x00, 13}, {third}}
+	stats := heap.Collect(stacks)
+
+	assert.Equal(t, PhaseStats{Pause: stats.Mark.Pause, Objects: 3, Bytes: 5 * wordSize}, stats.Mark)
+	assert.Equal(t, PhaseStats{Pause: stats.Sweep.Pause, Objects: 1, Bytes: 3 * wordSize}, stats.Sweep)
+	assert.Equal(t, PhaseStats{Pause: stats.Compact.Pause, Objects: 2, Bytes: 3 * wordSize}, stats.Compact)
+	assert.Equal(t, stats.Mark.Pause+stats.Sweep.Pause+stats.Compact.Pause, stats.Pause())
+
+	movedSecond := heapBase + 2*wordSize
+	movedThird := heapBase + 3*wordSize
+	assert.Equal(t, []uintptr{heapBase, movedSecond, movedThird}, heap.Objects())
+	assert.Equal(t, [][]uintptr{{heapBase, 0x00, 13}, {movedThird}}, stacks)
+
+	assert.Equal(t, movedThird+wordSize, heap.Load(heapBase))
+	assert.Equal(t, uintptr(0x10000), heap.Load(heapBase+wordSize)) // a scalar isn't rewritten
+	assert.Equal(t, uintptr(42), heap.Load(movedSecond))
+	assert.Equal(t, movedSecond, heap.Load(movedThird))
+	assert.Equal(t, uintptr(7), heap.Load(movedThird+wordSize))
+
+	// reclaimed memory can be allocated again
+	address := mustAlloc(t, heap, 11)
+	assert.Equal(t, heapBase+5*wordSize, address)
+	assert.Equal(t, uintptr(0), heap.Load(address))
+}
+
+// snapshot describes the reachable graph independently of addresses:
+// objects are numbered by the first scalar word, pointers are described
+// by the target number and the offset inside the target
+func snapshot(heap *Heap, roots []uintptr) map[uintptr][][2]uintptr {
+	graph := make(map[uintptr][][2]uintptr)
+	for _, address := range reachable(heap, roots) {
+		header, _ := heap.Header(address)
+
+		var edges [][2]uintptr
+		for slot := 1; slot < header.Size; slot++ {
+			ptr := heap.Load(address + uintptr(slot*wordSize))
+			if target := heap.find(ptr); header.Pointers.IsPointer(slot) && target != nil {
+				edges = append(edges, [2]uintptr{heap.Load(target.address), ptr - target.address})
+			} else {
+				edges = append(edges, [2]uintptr{ptr, 0})
+			}
+		}
+
+		graph[heap.Load(address)] = edges
+	}
+
+	return graph
+}
+
+func TestCollectRandomGraph(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	heap := NewHeap(1 << 16)
+
+	var addresses []uintptr
+	for id := 1; heap.used < len(heap.memory)/2; id++ {
+		size := 2 + random.Intn(6)
+		pointers := random.Perm(size - 1)[:random.Intn(size)]
+		for idx := range pointers {
+			pointers[idx]++ // the first word is the object number
+		}
+
+		address := mustAlloc(t, heap, size, pointers...)
+		heap.Store(address, uintptr(id))
+		addresses = append(addresses, address)
+	}
+
+	for _, address := range addresses {
+		header, _ := heap.Header(address)
+		for slot := 1; slot < header.Size; slot++ {
+			value := uintptr(random.Intn(1000))
+			if header.Pointers.IsPointer(slot) && random.Intn(4) == 0 {
+				target := addresses[random.Intn(len(addresses))]
+				targetHeader, _ := heap.Header(target)
+				value = target + uintptr(random.Intn(targetHeader.Size)*wordSize)
+			}
+			heap.Store(address+uintptr(slot*wordSize), value)
+		}
+	}
+
+	roots := make([]uintptr, 50)
+	for idx := range roots {
+		roots[idx] = addresses[random.Intn(len(addresses))]
+	}
+
+	expected := snapshot(heap, roots)
+	stats := heap.Collect([][]uintptr{roots})
+	require.Equal(t, expected, snapshot(heap, roots))
+
+	assert.Len(t, heap.Objects(), len(expected))
+	assert.Equal(t, stats.Mark.Objects, len(expected))
+	assert.Equal(t, len(addresses), stats.Mark.Objects+stats.Sweep.Objects)
+	assert.Equal(t, heap.address(heap.used), heapBase+uintptr(stats.Mark.Bytes))
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Node struct {
+	Address   uintptr `json:"address"`
+	Size      int     `json:"size"`      // in bytes
+	Retained  int     `json:"retained"`  // bytes freed if the object became unreachable
+	Dominator uintptr `json:"dominator"` // 0 if the object is dominated only by roots
+}
+
+type Root struct {
+	Stack int     `json:"stack"`
+	Slot  int     `json:"slot"`
+	To    uintptr `json:"to"`
+}
+
+type Edge struct {
+	From uintptr `json:"from"`
+	Slot int     `json:"slot"`
+	To   uintptr `json:"to"`
+}
+
+// ObjectGraph is the graph of objects marked by Trace, pointers
+// into the middle of objects are edges to their starts
+type ObjectGraph struct {
+	Roots   []Root `json:"roots"`
+	Objects []Node `json:"objects"`
+	Edges   []Edge `json:"edges"`
+
+	index    map[uintptr]int // address -> object index
+	outgoing [][]int         // object index -> edge indices
+}
+
+func (h *Heap) Graph(stacks [][]uintptr) *ObjectGraph {
+	graph := &ObjectGraph{}
+	for _, address := range h.Trace(stacks) {
+		header, _ := h.Header(address)
+		graph.Objects = append(graph.Objects, Node{Address: address, Size: header.Size * wordSize})
+	}
+
+	for stackIdx, stack := range stacks {
+		for slot, ptr := range stack {
+			if object := h.find(ptr); object != nil {
+				graph.Roots = append(graph.Roots, Root{Stack: stackIdx, Slot: slot, To: object.address})
+			}
+		}
+	}
+
+	for _, node := range graph.Objects {
+		header, _ := h.Header(node.Address)
+		for slot := 0; slot < header.Size; slot++ {
+			if !header.Pointers.IsPointer(slot) {
+				continue
+			}
+
+			ptr := h.Load(node.Address + uintptr(slot*wordSize))
+			if object := h.find(ptr); object != nil {
+				graph.Edges = append(graph.Edges, Edge{From: node.Address, Slot: slot, To: object.address})
+			}
+		}
+	}
+
+	if err := graph.buildIndex(); err != nil {
+		panic(err) // the graph is built from the heap, so it's consistent
+	}
+
+	graph.computeDominators()
+	return graph
+}
+
+// buildIndex fills index and outgoing from Objects and Edges
+func (g *ObjectGraph) buildIndex() error {
+	g.index = make(map[uintptr]int, len(g.Objects))
+	for idx, node := range g.Objects {
+		if idx > 0 && node.Address <= g.Objects[idx-1].Address {
+			return fmt.Errorf("objects aren't sorted by address at %#x", node.Address)
+		}
+
+		g.index[node.Address] = idx
+	}
+
+	for _, root := range g.Roots {
+		if _, ok := g.index[root.To]; !ok {
+			return fmt.Errorf("root points to unknown object %#x", root.To)
+		}
+	}
+
+	g.outgoing = make([][]int, len(g.Objects))
+	for edgeIdx, edge := range g.Edges {
+		from, ok := g.index[edge.From]
+		if _, found := g.index[edge.To]; !ok || !found {
+			return fmt.Errorf("edge %#x -> %#x refers to unknown object", edge.From, edge.To)
+		}
+
+		g.outgoing[from] = append(g.outgoing[from], edgeIdx)
+	}
+
+	return nil
+}
+
+// computeDominators builds the dominator tree with the iterative algorithm
+// by Cooper, Harvey and Kennedy. Vertex 0 is a virtual root pointing to
+// all roots, vertex idx+1 is the object idx. An object's retained size is
+// its size plus retained sizes of objects it immediately dominates
+func (g *ObjectGraph) computeDominators() {
+	successors := func(vertex int) []int {
+		var result []int
+		if vertex == 0 {
+			for _, root := range g.Roots {
+				result = append(result, g.index[root.To]+1)
+			}
+		} else {
+			for _, edge := range g.outgoing[vertex-1] {
+				result = append(result, g.index[g.Edges[edge].To]+1)
+			}
+		}
+		return result
+	}
+
+	vertices := len(g.Objects) + 1
+	postorder := make([]int, vertices) // vertex -> postorder number
+	predecessors := make([][]int, vertices)
+	visited := make([]bool, vertices)
+
+	var order []int // postorder
+	type frame struct {
+		vertex int
+		next   []int
+	}
+
+	stack := []frame{{vertex: 0, next: successors(0)}}
+	visited[0] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.next) == 0 {
+			postorder[top.vertex] = len(order)
+			order = append(order, top.vertex)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		successor := top.next[0]
+		top.next = top.next[1:]
+		predecessors[successor] = append(predecessors[successor], top.vertex)
+		if !visited[successor] {
+			visited[successor] = true
+			stack = append(stack, frame{vertex: successor, next: successors(successor)})
+		}
+	}
+
+	idom := make([]int, vertices)
+	for vertex := range idom {
+		idom[vertex] = -1
+	}
+	idom[0] = 0
+
+	intersect := func(lhs, rhs int) int {
+		for lhs != rhs {
+			for postorder[lhs] < postorder[rhs] {
+				lhs = idom[lhs]
+			}
+			for postorder[rhs] < postorder[lhs] {
+				rhs = idom[rhs]
+			}
+		}
+		return lhs
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for idx := len(order) - 2; idx >= 0; idx-- { // reverse postorder without the virtual root
+			vertex := order[idx]
+			newIdom := -1
+			for _, predecessor := range predecessors[vertex] {
+				if idom[predecessor] == -1 {
+					continue
+				}
+
+				if newIdom == -1 {
+					newIdom = predecessor
+				} else {
+					newIdom = intersect(predecessor, newIdom)
+				}
+			}
+
+			if idom[vertex] != newIdom {
+				idom[vertex] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	retained := make([]int, vertices)
+	for _, vertex := range order[:len(order)-1] { // dominated objects go before their dominators
+		retained[vertex] += g.Objects[vertex-1].Size
+		retained[idom[vertex]] += retained[vertex]
+
+		node := &g.Objects[vertex-1]
+		node.Retained = retained[vertex]
+		if idom[vertex] != 0 {
+			node.Dominator = g.Objects[idom[vertex]-1].Address
+		}
+	}
+}
+
+func (g *ObjectGraph) Node(ptr uintptr) (Node, bool) {
+	idx, ok := g.find(ptr)
+	if !ok {
+		return Node{}, false
+	}
+
+	return g.Objects[idx], true
+}
+
+type Path struct {
+	Root  Root
+	Edges []Edge
+}
+
+func (p Path) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "stack %d slot %d -> %#x", p.Root.Stack, p.Root.Slot, p.Root.To)
+	for _, edge := range p.Edges {
+		fmt.Fprintf(&builder, " -(slot %d)-> %#x", edge.Slot, edge.To)
+	}
+
+	return builder.String()
+}
+
+// RetentionPath returns the shortest chain of pointers from a root
+// stack slot to the object containing ptr. Graphs built by Graph have
+// only reachable objects, but a decoded graph can have any
+func (g *ObjectGraph) RetentionPath(ptr uintptr) (Path, bool) {
+	target, ok := g.find(ptr)
+	if !ok {
+		return Path{}, false
+	}
+
+	// via is an edge index used to reach an object or -(root index+1)
+	via := make([]int, len(g.Objects))
+	reached := make([]bool, len(g.Objects))
+
+	var queue []int
+	for rootIdx, root := range g.Roots {
+		if idx := g.index[root.To]; !reached[idx] {
+			reached[idx] = true
+			via[idx] = -(rootIdx + 1)
+			queue = append(queue, idx)
+		}
+	}
+
+	for len(queue) > 0 && !reached[target] {
+		idx := queue[0]
+		queue = queue[1:]
+
+		for _, edgeIdx := range g.outgoing[idx] {
+			if next := g.index[g.Edges[edgeIdx].To]; !reached[next] {
+				reached[next] = true
+				via[next] = edgeIdx
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	if !reached[target] {
+		return Path{}, false
+	}
+
+	var path Path
+	for idx := target; ; {
+		if via[idx] < 0 {
+			path.Root = g.Roots[-via[idx]-1]
+			break
+		}
+
+		edge := g.Edges[via[idx]]
+		path.Edges = append([]Edge{edge}, path.Edges...)
+		idx = g.index[edge.From]
+	}
+
+	return path, true
+}
+
+func (g *ObjectGraph) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+// UnmarshalJSON decodes a graph written by WriteJSON and rebuilds its indexes
+func (g *ObjectGraph) UnmarshalJSON(data []byte) error {
+	type fields ObjectGraph // without methods, so Unmarshal doesn't recurse
+
+	var decoded fields
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	graph := ObjectGraph(decoded)
+	if err := graph.buildIndex(); err != nil {
+		return err
+	}
+
+	*g = graph
+	return nil
+}
+
+// WriteDOT writes the graph for Graphviz: dot -Tsvg heap.dot > heap.svg
+func (g *ObjectGraph) WriteDOT(writer io.Writer) error {
+	var builder strings.Builder
+	builder.WriteString("digraph heap {\n")
+	builder.WriteString("\tnode [shape=box];\n")
+
+	stacks := make(map[int]struct{})
+	for _, root := range g.Roots {
+		if _, ok := stacks[root.Stack]; !ok {
+			stacks[root.Stack] = struct{}{}
+			fmt.Fprintf(&builder, "\t\"stack %d\" [shape=ellipse];\n", root.Stack)
+		}
+	}
+
+	for _, node := range g.Objects {
+		fmt.Fprintf(&builder, "\t\"%#x\" [label=\"%#x\\nsize %d\\nretained %d\"];\n",
+			node.Address, node.Address, node.Size, node.Retained)
+	}
+
+	for _, root := range g.Roots {
+		fmt.Fprintf(&builder, "\t\"stack %d\" -> \"%#x\" [label=\"%d\"];\n", root.Stack, root.To, root.Slot)
+	}
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&builder, "\t\"%#x\" -> \"%#x\" [label=\"%d\"];\n", edge.From, edge.To, edge.Slot)
+	}
+
+	builder.WriteString("}\n")
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+// find returns an index of the object containing ptr, objects are sorted by address
+func (g *ObjectGraph) find(ptr uintptr) (int, bool) {
+	idx := sort.Search(len(g.Objects), func(idx int) bool {
+		return g.Objects[idx].Address > ptr
+	}) - 1
+
+	if idx < 0 || ptr >= g.Objects[idx].Address+uintptr(g.Objects[idx].Size) {
+		return 0, false
+	}
+
+	return idx, true
+}
+
+func TestGraphDominators(t *testing.T) {
+	heap := NewHeap(64)
+
+	// a -> b -> d -> e
+	// a -> c -> d
+	a := mustAlloc(t, heap, 2, 0, 1)
+	b := mustAlloc(t, heap, 1, 0)
+	c := mustAlloc(t, heap, 1, 0)
+	d := mustAlloc(t, heap, 2, 0)
+	e := mustAlloc(t, heap, 3)
+	garbage := mustAlloc(t, heap, 1, 0)
+	heap.Store(a, b)
+	heap.Store(a+wordSize, c)
+	heap.Store(b, d)
+	heap.Store(c, d+wordSize) // interior pointer
+	heap.Store(d, e)
+	heap.Store(garbage, a)
+
+	graph := heap.Graph([][]uintptr{{0x00, a}})
+
+	assert.Equal(t, []Root{{Stack: 0, Slot: 1, To: a}}, graph.Roots)
+	assert.Equal(t, []Edge{
+		{From: a, Slot: 0, To: b},
+		{From: a, Slot: 1, To: c},
+		{From: b, Slot: 0, To: d},
+		{From: c, Slot: 0, To: d},
+		{From: d, Slot: 0, To: e},
+	}, graph.Edges)
+
+	assert.Equal(t, []Node{
+		{Address: a, Size: 16, Retained: 72, Dominator: 0},
+		{Address: b, Size: 8, Retained: 8, Dominator: a},
+		{Address: c, Size: 8, Retained: 8, Dominator: a},
+		{Address: d, Size: 16, Retained: 40, Dominator: a},
+		{Address: e, Size: 24, Retained: 24, Dominator: d},
+	}, graph.Objects)
+
+	_, ok := graph.Node(garbage)
+	assert.False(t, ok)
+}
+
+func TestGraphSharedByRoots(t *testing.T) {
+	heap := NewHeap(64)
+
+	first := mustAlloc(t, heap, 1, 0)
+	second := mustAlloc(t, heap, 1, 0)
+	shared := mustAlloc(t, heap, 4)
+	heap.Store(first, shared)
+	heap.Store(second, shared)
+
+	graph := heap.Graph([][]uintptr{{first}, {second}})
+
+	// nothing but the roots dominates the shared object, so it's retained by no one
+	node, ok := graph.Node(shared + wordSize)
+	require.True(t, ok)
+	assert.Equal(t, Node{Address: shared, Size: 32, Retained: 32}, node)
+
+	node, _ = graph.Node(first)
+	assert.Equal(t, 8, node.Retained)
+}
+
+// retainedByRemoval counts bytes that become unreachable without the object
+func retainedByRemoval(graph *ObjectGraph, removed uintptr) int {
+	visited := map[uintptr]struct{}{removed: {}}
+	var stack []uintptr
+	for _, root := range graph.Roots {
+		stack = append(stack, root.To)
+	}
+
+	for len(stack) > 0 {
+		address := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[address]; ok {
+			continue
+		}
+
+		visited[address] = struct{}{}
+		for _, edge := range graph.outgoing[graph.index[address]] {
+			stack = append(stack, graph.Edges[edge].To)
+		}
+	}
+
+	retained := 0
+	for _, node := range graph.Objects {
+		if _, ok := visited[node.Address]; !ok || node.Address == removed {
+			retained += node.Size
+		}
+	}
+
+	return retained
+}
+
+func TestGraphRetainedRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for iteration := 0; iteration < 50; iteration++ {
+		heap := NewHeap(1024)
+
+		addresses := make([]uintptr, 40)
+		for idx := range addresses {
+			addresses[idx] = mustAlloc(t, heap, 3, 0, 1)
+		}
+
+		for _, address := range addresses {
+			for slot := 0; slot < 2; slot++ {
+				if random.Intn(3) > 0 {
+					heap.Store(address+uintptr(slot*wordSize), addresses[random.Intn(len(addresses))])
+				}
+			}
+		}
+
+		graph := heap.Graph([][]uintptr{{addresses[0], addresses[random.Intn(len(addresses))]}})
+		for _, node := range graph.Objects {
+			require.Equal(t, retainedByRemoval(graph, node.Address), node.Retained)
+		}
+	}
+}
+
+func TestRetentionPath(t *testing.T) {
+	heap := NewHeap(64)
+
+	long := mustAlloc(t, heap, 1, 0)
+	middle := mustAlloc(t, heap, 1, 0)
+	short := mustAlloc(t, heap, 2, 1)
+	target := mustAlloc(t, heap, 2)
+	heap.Store(long, middle)
+	heap.Store(middle, target)
+	heap.Store(short+wordSize, target)
+
+	graph := heap.Graph([][]uintptr{{long}, {0x00, 0x00, short}})
+
+	path, ok := graph.RetentionPath(target + wordSize)
+	require.True(t, ok)
+	assert.Equal(t, Path{
+		Root:  Root{Stack: 1, Slot: 2, To: short},
+		Edges: []Edge{{From: short, Slot: 1, To: target}},
+	}, path)
+	assert.Equal(t, fmt.Sprintf("stack 1 slot 2 -> %#x -(slot 1)-> %#x", short, target), path.String())
+
+	path, ok = graph.RetentionPath(middle)
+	require.True(t, ok)
+	assert.Equal(t, Root{Stack: 0, Slot: 0, To: long}, path.Root)
+	assert.Len(t, path.Edges, 1)
+
+	path, ok = graph.RetentionPath(long)
+	require.True(t, ok)
+	assert.Empty(t, path.Edges)
+
+	_, ok = graph.RetentionPath(heapBase + 100*wordSize)
+	assert.False(t, ok)
+}
+
+// TestGraphLeakWithString models lessons/strings/leak_with_string:
+// a small substring keeps the whole data read from a file alive
+func TestGraphLeakWithString(t *testing.T) {
+	const dataSize = 1 << 12 // in words
+
+	heap := NewHeap(2 * dataSize)
+
+	array := mustAlloc(t, heap, dataSize)
+	data := mustAlloc(t, heap, 2, 0) // string header {ptr, len}
+	heap.Store(data, array)
+	heap.Store(data+wordSize, dataSize*wordSize)
+
+	sequence := mustAlloc(t, heap, 2, 0) // data[i+2 : i+22]
+	heap.Store(sequence, array+100*wordSize)
+	heap.Store(sequence+wordSize, 20)
+
+	// data isn't used after findSequence, only sequence is on the stack
+	graph := heap.Graph([][]uintptr{{sequence}})
+
+	path, ok := graph.RetentionPath(array)
+	require.True(t, ok)
+	assert.Equal(t, []Edge{{From: sequence, Slot: 0, To: array}}, path.Edges)
+
+	node, _ := graph.Node(sequence)
+	assert.Equal(t, 2*wordSize+dataSize*wordSize, node.Retained)
+
+	_, ok = graph.Node(data)
+	assert.False(t, ok)
+}
+
+func TestGraphExport(t *testing.T) {
+	heap := NewHeap(64)
+
+	parent := mustAlloc(t, heap, 2, 1)
+	child := mustAlloc(t, heap, 1)
+	heap.Store(parent+wordSize, child)
+
+	graph := heap.Graph([][]uintptr{{parent}})
+
+	var dot bytes.Buffer
+	require.NoError(t, graph.WriteDOT(&dot))
+	assert.Equal(t, `digraph heap {
+	node [shape=box];
+	"stack 0" [shape=ellipse];
+	"0x10000" [label="0x10000\nsize 16\nretained 24"];
+	"0x10010" [label="0x10010\nsize 8\nretained 8"];
+	"stack 0" -> "0x10000" [label="0"];
+	"0x10000" -> "0x10010" [label="1"];
+}
+`, dot.String())
+
+	var buffer bytes.Buffer
+	require.NoError(t, graph.WriteJSON(&buffer))
+
+	var decoded ObjectGraph
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, graph.Roots, decoded.Roots)
+	assert.Equal(t, graph.Objects, decoded.Objects)
+	assert.Equal(t, graph.Edges, decoded.Edges)
+
+	// decoded graph can be queried like the original one
+	path, ok := decoded.RetentionPath(child)
+	require.True(t, ok)
+	assert.Equal(t, []Edge{{From: parent, Slot: 1, To: child}}, path.Edges)
+
+	node, ok := decoded.Node(child)
+	require.True(t, ok)
+	assert.Equal(t, parent, node.Dominator)
+
+	invalid := `{"roots": [{"stack": 0, "slot": 0, "to": 1}], "objects": [], "edges": []}`
+	assert.Error(t, json.Unmarshal([]byte(invalid), &decoded))
+}
+
+func TestGraphDecodedUnreachable(t *testing.T) {
+	encoded := `{
+		"roots": [{"stack": 0, "slot": 0, "to": 16}],
+		"objects": [
+			{"address": 16, "size": 8},
+			{"address": 32, "size": 8},
+			{"address": 48, "size": 8}
+		],
+		"edges": [{"from": 48, "slot": 0, "to": 48}]
+	}`
+
+	var graph ObjectGraph
+	require.NoError(t, json.Unmarshal([]byte(encoded), &graph))
+
+	path, ok := graph.RetentionPath(16)
+	require.True(t, ok)
+	assert.Empty(t, path.Edges)
+
+	_, ok = graph.RetentionPath(32) // no roots or edges
+	assert.False(t, ok)
+
+	_, ok = graph.RetentionPath(48) // only a self-loop
+	assert.False(t, ok)
+
+	var empty ObjectGraph
+	require.NoError(t, json.Unmarshal([]byte(`{"roots": [], "objects": [{"address": 16, "size": 8}], "edges": []}`), &empty))
+	_, ok = empty.RetentionPath(16)
+	assert.False(t, ok)
+}
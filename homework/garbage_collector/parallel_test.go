@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// markBits is a bitmap of marks, one bit per object, claimed with CAS
+type markBits []atomic.Uint64
+
+func (m markBits) claim(idx int) bool {
+	word, bit := &m[idx/64], uint64(1)<<(idx%64)
+	for {
+		old := word.Load()
+		if old&bit != 0 {
+			return false
+		}
+
+		if word.CompareAndSwap(old, old|bit) {
+			return true
+		}
+	}
+}
+
+func (m markBits) isMarked(idx int) bool {
+	return m[idx/64].Load()&(1<<(idx%64)) != 0
+}
+
+// deque holds indices of grey objects, the owner works with the
+// bottom and thieves take the top half, where objects are older
+// and usually have bigger subgraphs
+type deque struct {
+	mutex sync.Mutex
+	items []int
+	_     [64]byte // objects of different workers are on different cache lines
+}
+
+func (d *deque) push(idx int) {
+	d.mutex.Lock()
+	d.items = append(d.items, idx)
+	d.mutex.Unlock()
+}
+
+func (d *deque) pop() (int, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if len(d.items) == 0 {
+		return 0, false
+	}
+
+	idx := d.items[len(d.items)-1]
+	d.items = d.items[:len(d.items)-1]
+	return idx, true
+}
+
+func (d *deque) stealHalf() []int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	half := (len(d.items) + 1) / 2
+	stolen := append([]int(nil), d.items[:half]...)
+	d.items = append(d.items[:0], d.items[half:]...)
+	return stolen
+}
+
+// ParallelTrace marks like Trace with several workers. Every worker
+// has its own deque and steals from others when it's empty. Marking
+// is finished when pending, the number of claimed but not scanned
+// objects, drops to zero: an object is counted before it's pushed
+// and uncounted only after its children are counted
+func (h *Heap) ParallelTrace(stacks [][]uintptr, workers int) []uintptr {
+	if h.marking {
+		panic("trace during an incremental cycle")
+	}
+
+	workers = max(workers, 1)
+	marks := make(markBits, (len(h.objects)+63)/64)
+	deques := make([]deque, workers)
+	pending := atomic.Int64{}
+
+	roots := 0
+	for _, stack := range stacks {
+		for _, ptr := range stack {
+			if idx := h.findIndex(ptr); idx >= 0 && marks.claim(idx) {
+				deques[roots%workers].push(idx)
+				roots++
+			}
+		}
+	}
+	pending.Add(int64(roots))
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func(worker int) {
+			defer wg.Done()
+
+			local := &deques[worker]
+			random := rand.New(rand.NewSource(int64(worker)))
+			for {
+				idx, ok := local.pop()
+				if !ok {
+					if pending.Load() == 0 {
+						return
+					}
+
+					if !h.steal(deques, worker, random) {
+						runtime.Gosched()
+					}
+					continue
+				}
+
+				object := h.objects[idx]
+				start := int(object.address-heapBase) / wordSize
+				delta := int64(-1)
+				for slot := 0; slot < object.header.Size; slot++ {
+					if !object.header.Pointers.IsPointer(slot) {
+						continue
+					}
+
+					if child := h.findIndex(h.memory[start+slot]); child >= 0 && marks.claim(child) {
+						local.push(child)
+						delta++
+					}
+				}
+
+				// children are counted before the parent is uncounted
+				if delta != 0 {
+					pending.Add(delta)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	var result []uintptr
+	for idx, object := range h.objects {
+		object.marked = marks.isMarked(idx)
+		if object.marked {
+			result = append(result, object.address)
+		}
+	}
+
+	return result
+}
+
+// steal moves half of a random victim's work to the worker's deque
+func (h *Heap) steal(deques []deque, worker int, random *rand.Rand) bool {
+	offset := random.Intn(len(deques))
+	for idx := range deques {
+		victim := (offset + idx) % len(deques)
+		if victim == worker {
+			continue
+		}
+
+		if stolen := deques[victim].stealHalf(); len(stolen) > 0 {
+			local := &deques[worker]
+			local.mutex.Lock()
+			local.items = append(local.items, stolen...)
+			local.mutex.Unlock()
+			return true
+		}
+	}
+
+	return false
+}
+
+// findIndex is like find, but returns an index of the object or -1
+func (h *Heap) findIndex(address uintptr) int {
+	if !h.Contains(address) {
+		return -1
+	}
+
+	idx := sort.Search(len(h.objects), func(idx int) bool {
+		return h.objects[idx].address > address
+	}) - 1
+
+	if idx < 0 || address >= h.objects[idx].end() {
+		return -1
+	}
+
+	return idx
+}
+
+type graph struct {
+	name  string
+	build func(heap *Heap, nodes int, random *rand.Rand) []uintptr // returns roots
+}
+
+var graphs = []graph{
+	{name: "list", build: buildList},
+	{name: "wide tree", build: buildWideTree},
+	{name: "random DAG", build: buildRandomDAG},
+}
+
+func buildList(heap *Heap, nodes int, _ *rand.Rand) []uintptr {
+	head := uintptr(0)
+	for idx := 0; idx < nodes; idx++ {
+		node, _ := heap.Alloc(2, 0)
+		heap.Store(node, head)
+		head = node
+	}
+
+	return []uintptr{head}
+}
+
+// buildWideTree builds a tree where every node has up to 64 children
+func buildWideTree(heap *Heap, nodes int, _ *rand.Rand) []uintptr {
+	const fanout = 64
+
+	pointers := make([]int, fanout)
+	for idx := range pointers {
+		pointers[idx] = idx
+	}
+
+	addresses := make([]uintptr, nodes)
+	for idx := range addresses {
+		if idx*fanout+1 < nodes {
+			addresses[idx], _ = heap.Alloc(fanout, pointers...)
+		} else {
+			addresses[idx], _ = heap.Alloc(1)
+		}
+
+		if idx > 0 {
+			parent := (idx - 1) / fanout
+			heap.Store(addresses[parent]+uintptr((idx-1)%fanout*wordSize), addresses[idx])
+		}
+	}
+
+	return addresses[:1]
+}
+
+// buildRandomDAG builds a graph where every node points to 4 earlier ones
+func buildRandomDAG(heap *Heap, nodes int, random *rand.Rand) []uintptr {
+	const edges = 4
+
+	addresses := make([]uintptr, nodes)
+	for idx := range addresses {
+		addresses[idx], _ = heap.Alloc(edges+1, 0, 1, 2, 3)
+		for edge := 0; edge < edges && idx > 0; edge++ {
+			heap.Store(addresses[idx]+uintptr(edge*wordSize), addresses[random.Intn(idx)])
+		}
+	}
+
+	return addresses[nodes-8:]
+}
+
+func TestParallelTraceMatchesTrace(t *testing.T) {
+	for _, graph := range graphs {
+		for _, workers := range []int{1, 2, 3, 8} {
+			t.Run(fmt.Sprintf("%s with %d workers", graph.name, workers), func(t *testing.T) {
+				random := rand.New(rand.NewSource(1))
+				heap := NewHeap(1 << 20)
+				roots := graph.build(heap, 10_000, random)
+
+				// garbage and conservative roots
+				for idx := 0; idx < 100; idx++ {
+					mustAlloc(t, heap, 2, 0)
+				}
+				stacks := [][]uintptr{roots, {0x00, 42, heapBase + 3, heap.Objects()[len(heap.Objects())-1]}}
+
+				expected := heap.Trace(stacks)
+				require.Equal(t, expected, heap.ParallelTrace(stacks, workers))
+			})
+		}
+	}
+}
+
+func TestParallelTraceMarksObjects(t *testing.T) {
+	heap := NewHeap(64)
+	first := mustAlloc(t, heap, 1, 0)
+	second := mustAlloc(t, heap, 1)
+	mustAlloc(t, heap, 1)
+	heap.Store(first, second)
+
+	stats := heap.Collect([][]uintptr{{first}})
+	assert.Equal(t, 2, stats.Mark.Objects)
+
+	// marks are the same as after Trace, so sweep can use them
+	assert.Equal(t, []uintptr{heapBase, heapBase + wordSize}, heap.ParallelTrace([][]uintptr{{heapBase}}, 4))
+	assert.Empty(t, heap.sweep())
+	assert.Empty(t, heap.ParallelTrace(nil, 4))
+}
+
+// go test -bench=ParallelTrace -run=^$ .
+
+func BenchmarkParallelTrace(b *testing.B) {
+	const nodes = 1_000_000
+
+	for _, graph := range graphs {
+		heap := NewHeap(8 * nodes)
+		roots := graph.build(heap, nodes, rand.New(rand.NewSource(1)))
+		stacks := [][]uintptr{roots}
+
+		b.Run(graph.name+"/sequential", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				heap.Trace(stacks)
+			}
+		})
+
+		for _, workers := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%s/%d workers", graph.name, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					heap.ParallelTrace(stacks, workers)
+				}
+			})
+		}
+	}
+}
@@ -1,58 +1,396 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/unicode/norm"
 )
 
+var ErrClosed = errors.New("cow buffer is closed")
+
+var leakReporter atomic.Pointer[func(stack string)]
+
+// SetLeakReporter enables reporting of buffers that are collected
+// without Close, the stack is where the leaked handle was created
+func SetLeakReporter(report func(stack string)) {
+	if report == nil {
+		leakReporter.Store(nil)
+		return
+	}
+
+	leakReporter.Store(&report)
+}
+
+type tracker struct {
+	stack string
+}
+
+func newTracker() *tracker {
+	report := leakReporter.Load()
+	if report == nil {
+		return nil
+	}
+
+	t := &tracker{stack: string(debug.Stack())}
+	runtime.SetFinalizer(t, func(t *tracker) {
+		(*report)(t.stack)
+	})
+
+	return t
+}
+
+func (t *tracker) stop() {
+	if t != nil {
+		runtime.SetFinalizer(t, nil)
+	}
+}
+
+// COWBuffer handles can be used from different goroutines, but
+// every handle itself belongs to one goroutine at a time. Any use
+// of a closed handle panics with ErrClosed, except Close which
+// returns it. A copy made by assignment is the same handle, not
+// a clone: when one copy is closed or detached by a write, the
+// others are closed too
 type COWBuffer struct {
-	data []byte
-	refs *int
+	data   []byte
+	refs   *atomic.Int64
+	handle *handle
+}
+
+// handle is the state of one handle shared by its copies
+type handle struct {
+	closed  atomic.Bool
+	tracker *tracker
 }
 
 func NewCOWBuffer(data []byte) COWBuffer {
-	refs := 1
+	refs := &atomic.Int64{}
+	refs.Store(1)
 	return COWBuffer{
-		data: data,
-		refs: &refs,
+		data:   data,
+		refs:   refs,
+		handle: &handle{tracker: newTracker()},
 	}
 }
 
 func (b *COWBuffer) Clone() COWBuffer {
-	*b.refs++
-	return *b
+	b.mustBeOpen()
+	b.refs.Add(1)
+	return COWBuffer{
+		data:   b.data,
+		refs:   b.refs,
+		handle: &handle{tracker: newTracker()},
+	}
 }
 
-func (b *COWBuffer) Close() {
-	*b.refs--
+func (b *COWBuffer) Close() error {
+	if b.handle == nil {
+		return ErrClosed
+	}
+
+	released := b.release()
+	*b = COWBuffer{}
+	if !released {
+		return ErrClosed // another copy of the handle was closed
+	}
+
+	return nil
 }
 
 func (b *COWBuffer) Update(index int, value byte) bool {
-	if b == nil {
+	b.mustBeOpen()
+	if index < 0 || index >= len(b.data) {
 		return false
 	}
 
-	if index < 0 || index >= len(b.data) {
+	if b.refs.Load() > 1 {
+		b.detach(slices.Clone(b.data))
+	}
+	b.data[index] = value
+
+	return true
+}
+
+// Slice returns a view that shares storage with the buffer,
+// the view and the buffer copy data only on the first write
+func (b *COWBuffer) Slice(start, end int) (COWBuffer, bool) {
+	b.mustBeOpen()
+	if start < 0 || end > len(b.data) || start > end {
+		return COWBuffer{}, false
+	}
+
+	b.refs.Add(1)
+	return COWBuffer{
+		data:   b.data[start:end:end], // append to the view can't overwrite the buffer
+		refs:   b.refs,
+		handle: &handle{tracker: newTracker()},
+	}, true
+}
+
+func (b *COWBuffer) Append(values ...byte) bool {
+	b.mustBeOpen()
+	if b.refs.Load() > 1 {
+		data := make([]byte, len(b.data), len(b.data)+len(values))
+		copy(data, b.data)
+		b.detach(data)
+	}
+	b.data = append(b.data, values...)
+
+	return true
+}
+
+func (b *COWBuffer) Insert(index int, values ...byte) bool {
+	b.mustBeOpen()
+	if index < 0 || index > len(b.data) {
 		return false
 	}
 
-	if *b.refs > 1 {
-		*b.refs--
-		*b = NewCOWBuffer(slices.Clone(b.data))
+	if b.refs.Load() > 1 {
+		data := make([]byte, 0, len(b.data)+len(values))
+		data = append(data, b.data[:index]...)
+		data = append(data, values...)
+		data = append(data, b.data[index:]...)
+		b.detach(data)
+		return true
 	}
-	b.data[index] = value
+	b.data = slices.Insert(b.data, index, values...)
+
+	return true
+}
+
+// Delete removes data[start:end] from the buffer
+func (b *COWBuffer) Delete(start, end int) bool {
+	b.mustBeOpen()
+	if start < 0 || end > len(b.data) || start > end {
+		return false
+	}
+
+	if b.refs.Load() > 1 {
+		data := make([]byte, 0, len(b.data)-(end-start))
+		data = append(data, b.data[:start]...)
+		data = append(data, b.data[end:]...)
+		b.detach(data)
+		return true
+	}
+	b.data = slices.Delete(b.data, start, end)
 
 	return true
 }
 
+// Bytes returns a copy of the data, the storage is shared with
+// clones, so a slice over it could change all of them
+func (b *COWBuffer) Bytes() []byte {
+	b.mustBeOpen()
+	return slices.Clone(b.data)
+}
+
+// String is the read-only view of the data without copying
 func (b *COWBuffer) String() string {
+	b.mustBeOpen()
 	return unsafe.String(unsafe.SliceData(b.data), len(b.data))
 }
 
+func (b *COWBuffer) RuneLen() int {
+	b.mustBeOpen()
+	return utf8.RuneCount(b.data)
+}
+
+func (b *COWBuffer) RuneAt(index int) (rune, bool) {
+	b.mustBeOpen()
+	start, size := runeBounds(b.data, index)
+	if start < 0 {
+		return utf8.RuneError, false
+	}
+
+	value, _ := utf8.DecodeRune(b.data[start : start+size])
+	return value, true
+}
+
+// UpdateRune replaces the index-th rune, the new rune
+// can take a different number of bytes than the old one
+func (b *COWBuffer) UpdateRune(index int, value rune) bool {
+	b.mustBeOpen()
+	if !utf8.ValidRune(value) {
+		return false
+	}
+
+	start, size := runeBounds(b.data, index)
+	if start < 0 {
+		return false
+	}
+
+	encoded := utf8.AppendRune(nil, value)
+	if b.refs.Load() > 1 {
+		data := make([]byte, 0, len(b.data)-size+len(encoded))
+		data = append(data, b.data[:start]...)
+		data = append(data, encoded...)
+		data = append(data, b.data[start+size:]...)
+		b.detach(data)
+		return true
+	}
+	b.data = slices.Replace(b.data, start, start+size, encoded...)
+
+	return true
+}
+
+// Validate returns *InvalidUTF8Error with the offset
+// of the first invalid sequence in the buffer
+func (b *COWBuffer) Validate() error {
+	b.mustBeOpen()
+	return validateUTF8(b.data)
+}
+
+// GraphemeLen returns the number of user-visible characters
+func (b *COWBuffer) GraphemeLen() int {
+	length := 0
+	b.EachGrapheme(func(string) bool {
+		length++
+		return true
+	})
+
+	return length
+}
+
+func (b *COWBuffer) EachGrapheme(action func(cluster string) bool) {
+	b.mustBeOpen()
+	eachGrapheme(b.data, func(cluster []byte) bool {
+		return action(unsafe.String(unsafe.SliceData(cluster), len(cluster)))
+	})
+}
+
+// detach releases the shared storage and takes ownership of data,
+// the copy has to be made before, so the last owner of the shared
+// storage can't change it during copying
+func (b *COWBuffer) detach(data []byte) {
+	b.release()
+	*b = NewCOWBuffer(data)
+}
+
+// release drops the handle's reference to the storage only once,
+// however many copies of the handle are closed
+func (b *COWBuffer) release() bool {
+	if !b.handle.closed.CompareAndSwap(false, true) {
+		return false
+	}
+
+	b.refs.Add(-1)
+	b.handle.tracker.stop()
+	return true
+}
+
+func (b *COWBuffer) mustBeOpen() {
+	if b == nil || b.handle == nil || b.handle.closed.Load() {
+		panic(ErrClosed)
+	}
+}
+
+type InvalidUTF8Error struct {
+	Offset int
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("invalid utf-8 sequence at offset %d", e.Offset)
+}
+
+func validateUTF8(data []byte) error {
+	for offset := 0; offset < len(data); {
+		value, size := utf8.DecodeRune(data[offset:])
+		if value == utf8.RuneError && size <= 1 {
+			return &InvalidUTF8Error{Offset: offset}
+		}
+
+		offset += size
+	}
+
+	return nil
+}
+
+// runeBounds returns the byte offset and the size of the index-th
+// rune, invalid bytes are counted as one rune like in utf8.RuneCount
+func runeBounds(data []byte, index int) (int, int) {
+	if index < 0 {
+		return -1, 0
+	}
+
+	for offset := 0; offset < len(data); index-- {
+		_, size := utf8.DecodeRune(data[offset:])
+		if index == 0 {
+			return offset, size
+		}
+
+		offset += size
+	}
+
+	return -1, 0
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// eachGrapheme approximates extended grapheme clusters: a segment between
+// normalization boundaries keeps a base rune with its combining marks, then
+// marks without a combining class (like spacing vowel signs in Indic
+// scripts), CRLF and emoji sequences with joiners, modifiers and flags
+// are glued together
+func eachGrapheme(data []byte, action func(cluster []byte) bool) {
+	for len(data) > 0 {
+		end := norm.NFC.NextBoundary(data, true)
+		regionalIndicators := 0
+		if first, _ := utf8.DecodeRune(data); isRegionalIndicator(first) {
+			regionalIndicators = 1
+		}
+
+		for end < len(data) {
+			previous, _ := utf8.DecodeLastRune(data[:end])
+			next, _ := utf8.DecodeRune(data[end:])
+
+			joined := previous == zeroWidthJoiner || next == zeroWidthJoiner ||
+				previous == '\r' && next == '\n' || unicode.In(next, unicode.M) ||
+				isVariationSelector(next) || isEmojiModifier(next) ||
+				isRegionalIndicator(next) && regionalIndicators%2 == 1
+			if !joined {
+				break
+			}
+
+			if isRegionalIndicator(next) {
+				regionalIndicators++
+			}
+
+			end += norm.NFC.NextBoundary(data[end:], true)
+		}
+
+		if !action(data[:end]) {
+			return
+		}
+
+		data = data[end:]
+	}
+}
+
+func isVariationSelector(value rune) bool {
+	return value >= 0xFE00 && value <= 0xFE0F
+}
+
+func isEmojiModifier(value rune) bool {
+	return value >= 0x1F3FB && value <= 0x1F3FF
+}
+
+func isRegionalIndicator(value rune) bool {
+	return value >= 0x1F1E6 && value <= 0x1F1FF
+}
+
 func TestCOWBuffer(t *testing.T) {
 	data := []byte{'a', 'b', 'c', 'd'}
 	buffer := NewCOWBuffer(data)
@@ -91,3 +429,276 @@ func TestCOWBuffer(t *testing.T) {
 
 	copy2.Close()
 }
+
+func TestCOWBufferClosed(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abcd"))
+	clone := buffer.Clone()
+
+	assert.NoError(t, buffer.Close())
+	assert.ErrorIs(t, buffer.Close(), ErrClosed)
+	assert.PanicsWithValue(t, ErrClosed, func() { buffer.Update(0, 'g') })
+	assert.PanicsWithValue(t, ErrClosed, func() { buffer.UpdateRune(0, 'g') })
+	assert.PanicsWithValue(t, ErrClosed, func() { _ = buffer.String() })
+	assert.PanicsWithValue(t, ErrClosed, func() { _ = buffer.Clone() })
+
+	previous := clone.data
+	assert.True(t, clone.Update(0, 'g')) // the last reference updates in place
+	assert.Equal(t, unsafe.SliceData(previous), unsafe.SliceData(clone.data))
+	assert.NoError(t, clone.Close())
+}
+
+func TestCOWBufferClosedCopy(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abcd"))
+	copied := buffer // a copy of the handle, not a clone
+
+	assert.NoError(t, buffer.Close())
+	assert.ErrorIs(t, copied.Close(), ErrClosed)
+	assert.PanicsWithValue(t, ErrClosed, func() { _ = copied.String() })
+
+	// the counter isn't broken by the second Close
+	buffer = NewCOWBuffer([]byte("abcd"))
+	copied = buffer
+	clone := buffer.Clone()
+	assert.NoError(t, buffer.Close())
+	assert.NoError(t, clone.Close())
+	assert.ErrorIs(t, copied.Close(), ErrClosed)
+	assert.Zero(t, copied.refs)
+}
+
+func TestCOWBufferClosedCopyWithClone(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abcd"))
+	clone := buffer.Clone()
+	copied := buffer
+
+	assert.NoError(t, buffer.Close())
+	assert.ErrorIs(t, copied.Close(), ErrClosed)
+	assert.Equal(t, int64(1), clone.refs.Load())
+
+	// the clone still shares storage with a new clone, so it isn't changed
+	another := clone.Clone()
+	assert.True(t, another.Update(0, 'X'))
+	assert.Equal(t, "abcd", clone.String())
+	assert.Equal(t, "Xbcd", another.String())
+
+	assert.NoError(t, clone.Close())
+	assert.NoError(t, another.Close())
+}
+
+func TestCOWBufferConcurrentClones(t *testing.T) {
+	data := []byte("abcdefgh")
+	buffer := NewCOWBuffer(data)
+
+	clones := make([]COWBuffer, 8)
+	for idx := range clones {
+		clones[idx] = buffer.Clone()
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(clones))
+	for idx := range clones {
+		go func(clone COWBuffer, idx int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				another := clone.Clone()
+				assert.True(t, another.Update(idx, 'x'))
+				assert.NoError(t, another.Close())
+			}
+
+			assert.True(t, clone.Update(idx, 'z'))
+			assert.Equal(t, byte('z'), clone.data[idx])
+			assert.NoError(t, clone.Close())
+		}(clones[idx], idx)
+	}
+
+	wg.Wait()
+	assert.Equal(t, "abcdefgh", buffer.String())
+	assert.Equal(t, int64(1), buffer.refs.Load())
+	assert.NoError(t, buffer.Close())
+}
+
+func TestCOWBufferLeakDetection(t *testing.T) {
+	leaks := make(chan string, 1)
+	SetLeakReporter(func(stack string) {
+		select {
+		case leaks <- stack:
+		default:
+		}
+	})
+	defer SetLeakReporter(nil)
+
+	func() {
+		buffer := NewCOWBuffer([]byte("abcd"))
+		clone := buffer.Clone()
+		_ = clone // leaked without Close
+		assert.NoError(t, buffer.Close())
+	}()
+
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case stack := <-leaks:
+			assert.Contains(t, stack, "TestCOWBufferLeakDetection")
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	t.Fatal("leaked buffer isn't reported")
+}
+
+func TestCOWBufferSlice(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abcdef"))
+	defer buffer.Close()
+
+	view, ok := buffer.Slice(1, 4)
+	assert.True(t, ok)
+	assert.Equal(t, "bcd", view.String())
+	assert.Equal(t, unsafe.SliceData(buffer.data[1:]), unsafe.SliceData(view.data))
+
+	_, ok = buffer.Slice(4, 7)
+	assert.False(t, ok)
+
+	assert.True(t, view.Update(0, 'x')) // copies only the view
+	assert.Equal(t, "xcd", view.String())
+	assert.Equal(t, "abcdef", buffer.String())
+	assert.Len(t, view.data, 3)
+
+	another, _ := buffer.Slice(0, 2)
+	assert.True(t, another.Append('z')) // doesn't overwrite the buffer
+	assert.Equal(t, "abz", another.String())
+	assert.Equal(t, "abcdef", buffer.String())
+
+	assert.NoError(t, view.Close())
+	assert.NoError(t, another.Close())
+
+	previous := buffer.data
+	assert.True(t, buffer.Update(0, 'g')) // the last reference updates in place
+	assert.Equal(t, unsafe.SliceData(previous), unsafe.SliceData(buffer.data))
+}
+
+func TestCOWBufferStructuralEdits(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("abcdef"))
+	clone := buffer.Clone()
+
+	assert.True(t, buffer.Insert(3, '-', '-'))
+	assert.Equal(t, "abc--def", buffer.String())
+	assert.Equal(t, "abcdef", clone.String())
+
+	assert.False(t, clone.Insert(7, 'x'))
+	assert.False(t, clone.Delete(2, 1))
+
+	assert.True(t, clone.Delete(1, 3))
+	assert.Equal(t, "adef", clone.String())
+	assert.Equal(t, "abc--def", buffer.String())
+
+	// sole owners edit in place
+	previous := buffer.data
+	assert.True(t, buffer.Delete(3, 5))
+	assert.Equal(t, "abcdef", buffer.String())
+	assert.Equal(t, unsafe.SliceData(previous), unsafe.SliceData(buffer.data))
+
+	assert.True(t, clone.Append('g', 'h'))
+	assert.True(t, clone.Insert(0, '>'))
+	assert.Equal(t, ">adefgh", clone.String())
+
+	shared := buffer.Clone()
+	bytes := buffer.Bytes()
+	assert.Equal(t, "abcdef", string(bytes))
+
+	bytes[0] = 'x' // the copy doesn't change the storage
+	assert.Equal(t, "abcdef", buffer.String())
+	assert.Equal(t, "abcdef", shared.String())
+	assert.NoError(t, shared.Close())
+
+	assert.NoError(t, buffer.Close())
+	assert.NoError(t, clone.Close())
+	assert.PanicsWithValue(t, ErrClosed, func() { buffer.Append('x') })
+	assert.PanicsWithValue(t, ErrClosed, func() { buffer.Insert(0, 'x') })
+	assert.PanicsWithValue(t, ErrClosed, func() { buffer.Delete(0, 1) })
+}
+
+func TestCOWBufferRunes(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("привет, мир"))
+	clone := buffer.Clone()
+
+	assert.Equal(t, 11, buffer.RuneLen())
+	value, ok := buffer.RuneAt(1)
+	assert.True(t, ok)
+	assert.Equal(t, 'р', value)
+	_, ok = buffer.RuneAt(11)
+	assert.False(t, ok)
+
+	assert.True(t, buffer.UpdateRune(0, 'P'))  // 2 bytes -> 1 byte
+	assert.True(t, buffer.UpdateRune(10, '界')) // 2 bytes -> 3 bytes
+	assert.False(t, buffer.UpdateRune(11, 'x'))
+	assert.False(t, buffer.UpdateRune(0, utf8.MaxRune+1))
+
+	assert.Equal(t, "Pривет, ми界", buffer.String())
+	assert.Equal(t, "привет, мир", clone.String())
+	assert.NoError(t, buffer.Validate())
+
+	// sole owner replaces runes in place
+	assert.NoError(t, clone.Close())
+	assert.True(t, buffer.UpdateRune(1, 'R'))
+	assert.Equal(t, "PRивет, ми界", buffer.String())
+	assert.NoError(t, buffer.Close())
+}
+
+func TestCOWBufferValidate(t *testing.T) {
+	buffer := NewCOWBuffer([]byte("привет"))
+	defer buffer.Close()
+
+	assert.True(t, buffer.Update(1, 'x')) // splits the first rune
+
+	var invalid *InvalidUTF8Error
+	assert.ErrorAs(t, buffer.Validate(), &invalid)
+	assert.Equal(t, 0, invalid.Offset)
+	assert.NoError(t, buffer.Close())
+
+	buffer = NewCOWBuffer([]byte("ok\xffok"))
+	assert.ErrorAs(t, buffer.Validate(), &invalid)
+	assert.Equal(t, 2, invalid.Offset)
+}
+
+func TestCOWBufferGraphemes(t *testing.T) {
+	text := "e\u0301a👩\u200d💻👍🏽🇷🇺🇰🇿❤\ufe0f"
+	buffer := NewCOWBuffer([]byte(text))
+	defer buffer.Close()
+
+	var clusters []string
+	buffer.EachGrapheme(func(cluster string) bool {
+		clusters = append(clusters, cluster)
+		return true
+	})
+
+	assert.Equal(t, []string{"e\u0301", "a", "👩\u200d💻", "👍🏽", "🇷🇺", "🇰🇿", "❤\ufe0f"}, clusters)
+	assert.Equal(t, 7, buffer.GraphemeLen())
+	assert.Equal(t, 14, buffer.RuneLen())
+}
+
+func TestCOWBufferGraphemesMarksAndCRLF(t *testing.T) {
+	tests := []struct {
+		text     string
+		clusters []string
+	}{
+		{text: "कि", clusters: []string{"कि"}},                 // spacing vowel sign, Mc
+		{text: "நி", clusters: []string{"நி"}},                 // spacing vowel sign, Mc
+		{text: "नु", clusters: []string{"नु"}},                 // nonspacing mark without combining class
+		{text: "a\r\nb", clusters: []string{"a", "\r\n", "b"}}, // CRLF is one cluster
+		{text: "\n\r", clusters: []string{"\n", "\r"}},
+	}
+
+	for _, test := range tests {
+		buffer := NewCOWBuffer([]byte(test.text))
+
+		var clusters []string
+		buffer.EachGrapheme(func(cluster string) bool {
+			clusters = append(clusters, cluster)
+			return true
+		})
+
+		assert.Equal(t, test.clusters, clusters, test.text)
+		assert.Equal(t, len(test.clusters), buffer.GraphemeLen(), test.text)
+		assert.NoError(t, buffer.Close())
+	}
+}
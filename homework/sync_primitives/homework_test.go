@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -9,34 +12,99 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+type Policy int
+
+const (
+	WriterPreference Policy = iota // readers wait while any writer waits
+	ReaderPreference               // writers wait until there are no readers
+	PhaseFair                      // read and write phases alternate, writers are served FIFO
+)
+
+// PhaseFair gives the guarantees of the ticket-based phase-fair lock
+// (Brandenburg and Anderson) with blocking queues instead of spinning:
+//   - a reader waits for at most one write phase: all readers queued
+//     during a write phase enter together when it ends, before the next
+//     writer, like readers waiting for the writer phase bit to change
+//   - a writer waits for at most one read phase: readers arriving while
+//     a writer is queued are queued too, like readers that see the
+//     writer present bit, so the current readers can only leave
+//   - writers enter in FIFO order of writeWait, which plays the role
+//     of writer tickets
+// Queues also allow targeted wakeups and cancellation of a waiter,
+// a ticket can't be withdrawn without blocking everyone behind it
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newWaiter() *waiter {
+	return &waiter{ready: make(chan struct{})}
+}
+
+func (w *waiter) grant() {
+	w.granted = true
+	close(w.ready)
+}
+
 type RWMutex struct {
 	mutex     *sync.Mutex
-	cond      *sync.Cond
+	policy    Policy
 	readers   int
 	writer    bool
-	writeWait int
+	upgrader  bool // upgradable reader holds the lock
+	upgrading bool // upgradable reader waits for readers to leave
+
+	readWait    []*waiter
+	writeWait   []*waiter
+	upgradeWait []*waiter
+	upgradeTo   *waiter
+}
+
+func WithPolicy(policy Policy) func(*RWMutex) {
+	return func(m *RWMutex) {
+		m.policy = policy
+	}
 }
 
-func NewRWMutex() *RWMutex {
-	mutex := &sync.Mutex{}
-	return &RWMutex{
-		mutex: mutex,
-		cond:  sync.NewCond(mutex),
+func NewRWMutex(options ...func(*RWMutex)) *RWMutex {
+	m := &RWMutex{mutex: &sync.Mutex{}}
+	for _, option := range options {
+		option(m)
 	}
+
+	return m
 }
 
 func (m *RWMutex) Lock() {
+	_ = m.LockContext(context.Background())
+}
+
+func (m *RWMutex) TryLock() bool {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.writeWait++
-
-	for m.readers > 0 || m.writer {
-		m.cond.Wait()
+	if !m.canLock() || len(m.writeWait) > 0 {
+		return false
 	}
 
-	m.writeWait--
 	m.writer = true
+	return true
+}
+
+func (m *RWMutex) LockContext(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.canLock() && len(m.writeWait) == 0 {
+		m.writer = true
+		m.mutex.Unlock()
+		return nil
+	}
+
+	w := newWaiter()
+	m.writeWait = append(m.writeWait, w)
+	m.mutex.Unlock()
+
+	return m.wait(ctx, w, &m.writeWait)
 }
 
 func (m *RWMutex) Unlock() {
@@ -44,18 +112,42 @@ func (m *RWMutex) Unlock() {
 	defer m.mutex.Unlock()
 
 	m.writer = false
-	m.cond.Broadcast()
+	if m.policy == PhaseFair {
+		m.grantReaders() // readers queued during the write phase go first
+	}
+
+	m.dispatch()
 }
 
 func (m *RWMutex) RLock() {
+	_ = m.RLockContext(context.Background())
+}
+
+func (m *RWMutex) TryRLock() bool {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	for m.writer || m.writeWait > 0 {
-		m.cond.Wait()
+	if !m.canRLock() {
+		return false
 	}
 
 	m.readers++
+	return true
+}
+
+func (m *RWMutex) RLockContext(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.canRLock() {
+		m.readers++
+		m.mutex.Unlock()
+		return nil
+	}
+
+	w := newWaiter()
+	m.readWait = append(m.readWait, w)
+	m.mutex.Unlock()
+
+	return m.wait(ctx, w, &m.readWait)
 }
 
 func (m *RWMutex) RUnlock() {
@@ -64,8 +156,153 @@ func (m *RWMutex) RUnlock() {
 
 	m.readers--
 	if m.readers == 0 {
-		m.cond.Broadcast()
+		m.dispatch()
+	}
+}
+
+func (m *RWMutex) UpgradableRLock() {
+	m.mutex.Lock()
+	if m.canRLock() && !m.upgrader {
+		m.upgrader = true
+		m.mutex.Unlock()
+		return
+	}
+
+	w := newWaiter()
+	m.upgradeWait = append(m.upgradeWait, w)
+	m.mutex.Unlock()
+
+	_ = m.wait(context.Background(), w, &m.upgradeWait)
+}
+
+func (m *RWMutex) UpgradableRUnlock() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.upgrader = false
+	m.dispatch()
+}
+
+// Upgrade turns the upgradable read lock into the write lock,
+// new readers are not admitted while it waits for current ones
+func (m *RWMutex) Upgrade() {
+	m.mutex.Lock()
+	if m.readers == 0 {
+		m.upgrader = false
+		m.writer = true
+		m.mutex.Unlock()
+		return
+	}
+
+	m.upgrading = true
+	m.upgradeTo = newWaiter()
+	ready := m.upgradeTo.ready
+	m.mutex.Unlock()
+
+	<-ready
+}
+
+// Downgrade turns the write lock into a read lock
+// without a window for another writer
+func (m *RWMutex) Downgrade() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.writer = false
+	m.readers++
+	if m.policy == PhaseFair {
+		m.grantReaders()
 	}
+
+	m.dispatch()
+}
+
+func (m *RWMutex) wait(ctx context.Context, w *waiter, queue *[]*waiter) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if w.granted {
+		return nil // the lock was handed over before cancellation
+	}
+
+	for idx, queued := range *queue {
+		if queued == w {
+			*queue = append((*queue)[:idx], (*queue)[idx+1:]...)
+			break
+		}
+	}
+
+	m.dispatch() // readers could wait only for this writer
+	return ctx.Err()
+}
+
+// dispatch wakes up only the waiters that can enter under the current policy
+func (m *RWMutex) dispatch() {
+	if m.writer {
+		return
+	}
+
+	if m.upgrading {
+		if m.readers == 0 {
+			m.upgrading = false
+			m.upgrader = false
+			m.writer = true
+			m.upgradeTo.grant()
+			m.upgradeTo = nil
+		}
+		return
+	}
+
+	if m.policy == ReaderPreference {
+		m.grantReaders()
+	}
+
+	if len(m.writeWait) > 0 && m.canLock() {
+		m.writer = true
+		m.writeWait[0].grant()
+		m.writeWait = m.writeWait[1:]
+		return
+	}
+
+	m.grantReadersIf(m.canRLock)
+}
+
+func (m *RWMutex) grantReaders() {
+	m.grantReadersIf(func() bool { return true })
+}
+
+func (m *RWMutex) grantReadersIf(admit func() bool) {
+	if admit() {
+		for _, w := range m.readWait {
+			m.readers++
+			w.grant()
+		}
+		m.readWait = nil
+	}
+
+	if len(m.upgradeWait) > 0 && !m.upgrader && admit() {
+		m.upgrader = true
+		m.upgradeWait[0].grant()
+		m.upgradeWait = m.upgradeWait[1:]
+	}
+}
+
+func (m *RWMutex) canLock() bool {
+	return m.readers == 0 && !m.writer && !m.upgrader && !m.upgrading
+}
+
+func (m *RWMutex) canRLock() bool {
+	if m.writer || m.upgrading {
+		return false
+	}
+
+	return m.policy == ReaderPreference || len(m.writeWait) == 0
 }
 
 func TestRWMutexWithWriter(t *testing.T) {
@@ -160,3 +397,290 @@ func TestRWMutexWithWriterPriority(t *testing.T) {
 	assert.True(t, mutualExlusionWithWriter.Load())
 	assert.Equal(t, int32(1), readersCount.Load())
 }
+
+func TestRWMutexTryLock(t *testing.T) {
+	mutex := NewRWMutex()
+	assert.True(t, mutex.TryLock())
+	assert.False(t, mutex.TryLock())
+	assert.False(t, mutex.TryRLock())
+
+	mutex.Unlock()
+	assert.True(t, mutex.TryRLock())
+	assert.True(t, mutex.TryRLock())
+	assert.False(t, mutex.TryLock())
+
+	mutex.RUnlock()
+	mutex.RUnlock()
+	assert.True(t, mutex.TryLock())
+}
+
+func TestRWMutexLockContextCancel(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.RLock() // reader
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := mutex.LockContext(ctx) // writer gives up waiting for reader
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	rlocked := make(chan struct{})
+	go func() {
+		mutex.RLock() // another reader isn't blocked by the cancelled writer
+		close(rlocked)
+	}()
+
+	select {
+	case <-rlocked:
+	case <-time.After(time.Second):
+		t.Fatal("reader is blocked by the cancelled writer")
+	}
+
+	mutex.RUnlock()
+	mutex.RUnlock()
+	assert.NoError(t, mutex.LockContext(context.Background()))
+}
+
+func TestRWMutexRLockContextCancel(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.Lock() // writer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	err := mutex.RLockContext(ctx) // reader gives up waiting for writer
+	assert.ErrorIs(t, err, context.Canceled)
+
+	mutex.Unlock()
+	assert.NoError(t, mutex.RLockContext(context.Background()))
+	mutex.RUnlock()
+}
+
+func TestRWMutexLockContextAcquire(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.Lock() // writer
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, mutex.LockContext(ctx))
+	assert.False(t, mutex.TryRLock())
+}
+
+func TestRWMutexUpgrade(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.UpgradableRLock()
+	assert.True(t, mutex.TryRLock()) // readers share the lock with upgrader
+	assert.False(t, mutex.TryLock())
+
+	var upgraded atomic.Bool
+	go func() {
+		mutex.Upgrade() // upgrader is waiting for reader
+		upgraded.Store(true)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, upgraded.Load())
+	assert.False(t, mutex.TryRLock()) // new readers wait for upgrader
+
+	mutex.RUnlock()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, upgraded.Load())
+	assert.False(t, mutex.TryRLock())
+
+	mutex.Unlock()
+	assert.True(t, mutex.TryLock())
+}
+
+func TestRWMutexSingleUpgrader(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.UpgradableRLock()
+
+	var secondUpgrader atomic.Bool
+	go func() {
+		mutex.UpgradableRLock() // another upgrader
+		secondUpgrader.Store(true)
+		mutex.Upgrade()
+		mutex.Unlock()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, secondUpgrader.Load())
+
+	mutex.Upgrade()
+	mutex.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, secondUpgrader.Load())
+	assert.True(t, mutex.TryLock())
+}
+
+func TestRWMutexDowngrade(t *testing.T) {
+	mutex := NewRWMutex()
+	mutex.Lock() // writer
+
+	var writerLocked atomic.Bool
+	go func() {
+		mutex.Lock() // another writer
+		writerLocked.Store(true)
+		mutex.Unlock()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	mutex.Downgrade()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, writerLocked.Load())
+
+	mutex.RUnlock()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, writerLocked.Load())
+}
+
+func TestRWMutexReaderPreference(t *testing.T) {
+	mutex := NewRWMutex(WithPolicy(ReaderPreference))
+	mutex.RLock() // reader
+
+	var writerLocked atomic.Bool
+	go func() {
+		mutex.Lock() // writer is waiting for readers
+		writerLocked.Store(true)
+		mutex.Unlock()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, mutex.TryRLock()) // another reader overtakes the writer
+	assert.False(t, writerLocked.Load())
+
+	mutex.RUnlock()
+	mutex.RUnlock()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, writerLocked.Load())
+}
+
+func TestRWMutexPhaseFair(t *testing.T) {
+	mutex := NewRWMutex(WithPolicy(PhaseFair))
+	mutex.Lock() // writer
+
+	var writerLocked atomic.Bool
+	go func() {
+		mutex.Lock() // another writer is waiting for the first one
+		writerLocked.Store(true)
+		mutex.Unlock()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	var readersCount atomic.Int32
+	for i := 0; i < 2; i++ {
+		go func() {
+			mutex.RLock() // readers are waiting for the end of the write phase
+			readersCount.Add(1)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	mutex.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), readersCount.Load()) // readers go before the queued writer
+	assert.False(t, writerLocked.Load())
+	assert.False(t, mutex.TryRLock()) // new readers wait for the queued writer
+
+	mutex.RUnlock()
+	mutex.RUnlock()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, writerLocked.Load())
+}
+
+func TestRWMutexPoliciesMutualExclusion(t *testing.T) {
+	for _, policy := range []Policy{WriterPreference, ReaderPreference, PhaseFair} {
+		mutex := NewRWMutex(WithPolicy(policy))
+
+		var readers, writers atomic.Int32
+		var violations atomic.Int32
+
+		wg := sync.WaitGroup{}
+		wg.Add(20)
+		for i := 0; i < 20; i++ {
+			go func(idx int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					if (idx+j)%4 == 0 {
+						mutex.Lock()
+						if writers.Add(1) != 1 || readers.Load() != 0 {
+							violations.Add(1)
+						}
+						writers.Add(-1)
+						mutex.Unlock()
+					} else {
+						mutex.RLock()
+						readers.Add(1)
+						if writers.Load() != 0 {
+							violations.Add(1)
+						}
+						readers.Add(-1)
+						mutex.RUnlock()
+					}
+				}
+			}(i)
+		}
+
+		wg.Wait()
+		assert.Zero(t, violations.Load())
+		assert.True(t, mutex.TryLock())
+	}
+}
+
+// go test -bench=. homework_test.go
+
+func BenchmarkRWMutexPolicies(b *testing.B) {
+	policies := []struct {
+		name   string
+		policy Policy
+	}{
+		{"WriterPreference", WriterPreference},
+		{"ReaderPreference", ReaderPreference},
+		{"PhaseFair", PhaseFair},
+	}
+
+	for _, writePercent := range []int{1, 10, 50} {
+		for _, p := range policies {
+			b.Run(fmt.Sprintf("%s/writes=%d%%", p.name, writePercent), func(b *testing.B) {
+				mutex := NewRWMutex(WithPolicy(p.policy))
+				benchmarkRWMutex(b, mutex.Lock, mutex.Unlock, mutex.RLock, mutex.RUnlock, writePercent)
+			})
+		}
+
+		b.Run(fmt.Sprintf("sync.RWMutex/writes=%d%%", writePercent), func(b *testing.B) {
+			var mutex sync.RWMutex
+			benchmarkRWMutex(b, mutex.Lock, mutex.Unlock, mutex.RLock, mutex.RUnlock, writePercent)
+		})
+	}
+}
+
+func benchmarkRWMutex(b *testing.B, lock, unlock, rlock, runlock func(), writePercent int) {
+	var number int32
+	b.SetParallelism(runtime.NumCPU())
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if i%100 < writePercent {
+				lock()
+				number++
+				unlock()
+			} else {
+				rlock()
+				_ = number
+				runlock()
+			}
+		}
+	})
+}
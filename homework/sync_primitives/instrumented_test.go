@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const histogramBuckets = 24
+
+// Histogram counts durations in power of two buckets:
+// bucket 0 is under 1µs, bucket i is under 2^i µs, the last one is the rest
+type Histogram struct {
+	Buckets [histogramBuckets]int64
+	Count   int64
+	Total   time.Duration
+	Max     time.Duration
+}
+
+func (h *Histogram) Observe(duration time.Duration) {
+	idx := 0
+	for bound := time.Microsecond; duration >= bound && idx < histogramBuckets-1; bound *= 2 {
+		idx++
+	}
+
+	h.Buckets[idx]++
+	h.Count++
+	h.Total += duration
+	h.Max = max(h.Max, duration)
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return h.Total / time.Duration(h.Count)
+}
+
+type SiteStats struct {
+	Wait Histogram
+	Hold Histogram
+}
+
+type ReportKind int
+
+const (
+	LockInversion ReportKind = iota
+	RecursiveLock
+)
+
+// Report describes a potential deadlock, the first site and stack belong
+// to the earlier acquisition and the second ones to the current acquisition.
+// Locks[1] is acquired while Locks[0] is held. For lock inversion Cycle is
+// the earlier order from Locks[1] to Locks[0], it can pass through other
+// locks, the earlier site is where the last lock of the cycle was acquired
+type Report struct {
+	Kind   ReportKind
+	Locks  [2]string
+	Sites  [2]string
+	Stacks [2]string
+	Cycle  []string
+}
+
+func (r Report) String() string {
+	switch r.Kind {
+	case LockInversion:
+		return fmt.Sprintf("lock inversion: earlier %s (%s -> %s at %s), now %s -> %s at %s",
+			strings.Join(r.Cycle, " -> "), r.Cycle[len(r.Cycle)-2], r.Locks[0], r.Sites[0],
+			r.Locks[0], r.Locks[1], r.Sites[1])
+	default:
+		return fmt.Sprintf("recursive lock of %s: first at %s, again at %s", r.Locks[0], r.Sites[0], r.Sites[1])
+	}
+}
+
+type lockMode int
+
+const (
+	writeMode lockMode = iota
+	readMode
+	upgradableMode
+)
+
+type lockInfo struct {
+	name string
+}
+
+type heldLock struct {
+	lock       *lockInfo
+	mode       lockMode
+	site       string
+	stack      string
+	acquiredAt time.Time
+}
+
+type orderEdge struct {
+	site  string
+	stack string
+}
+
+// Monitor collects contention statistics per lock site and keeps the order
+// in which goroutines acquire locks, stacks are captured only in debug mode
+type Monitor struct {
+	mutex   sync.Mutex
+	debug   bool
+	sites   map[string]*SiteStats
+	held    map[int64][]heldLock
+	order   map[*lockInfo]map[*lockInfo]orderEdge
+	reports []Report
+}
+
+var defaultMonitor = NewMonitor(false)
+
+func NewMonitor(debug bool) *Monitor {
+	return &Monitor{
+		debug: debug,
+		sites: make(map[string]*SiteStats),
+		held:  make(map[int64][]heldLock),
+		order: make(map[*lockInfo]map[*lockInfo]orderEdge),
+	}
+}
+
+func (m *Monitor) Stats() map[string]SiteStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := make(map[string]SiteStats, len(m.sites))
+	for site, siteStats := range m.sites {
+		stats[site] = *siteStats
+	}
+
+	return stats
+}
+
+func (m *Monitor) Reports() []Report {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Report(nil), m.reports...)
+}
+
+// acquire runs before the goroutine blocks on the lock, so
+// recursive locking is reported even if it never returns
+func (m *Monitor) acquire(lock *lockInfo, site string) (int64, time.Time) {
+	gid := goroutineID()
+	var stack string
+	if m.debug {
+		stack = currentStack()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, held := range m.held[gid] {
+		if held.lock == lock {
+			if m.debug {
+				m.reports = append(m.reports, Report{
+					Kind:   RecursiveLock,
+					Locks:  [2]string{lock.name, lock.name},
+					Sites:  [2]string{held.site, site},
+					Stacks: [2]string{held.stack, stack},
+				})
+			}
+			continue
+		}
+
+		m.addEdge(held, lock, site, stack)
+	}
+
+	return gid, time.Now()
+}
+
+// tryAcquired records a lock taken without blocking, it can't
+// deadlock, so it isn't checked for recursion or lock order
+func (m *Monitor) tryAcquired(lock *lockInfo, mode lockMode, site string) {
+	m.acquired(lock, mode, site, goroutineID(), time.Now())
+}
+
+func (m *Monitor) acquired(lock *lockInfo, mode lockMode, site string, gid int64, start time.Time) {
+	var stack string
+	if m.debug {
+		stack = currentStack()
+	}
+
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.site(site).Wait.Observe(now.Sub(start))
+	m.held[gid] = append(m.held[gid], heldLock{
+		lock:       lock,
+		mode:       mode,
+		site:       site,
+		stack:      stack,
+		acquiredAt: now,
+	})
+}
+
+func (m *Monitor) release(lock *lockInfo, mode lockMode) {
+	gid := goroutineID()
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// sync.Mutex may be unlocked by another goroutine
+	if m.releaseHeld(gid, lock, mode, now) {
+		return
+	}
+
+	for other := range m.held {
+		if m.releaseHeld(other, lock, mode, now) {
+			return
+		}
+	}
+}
+
+// convert changes the mode of a held lock on upgrade or downgrade,
+// the hold time is still counted from the first acquisition
+func (m *Monitor) convert(lock *lockInfo, from, to lockMode, site string, start time.Time) {
+	gid := goroutineID()
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.site(site).Wait.Observe(now.Sub(start))
+	locks := m.held[gid]
+	for idx := len(locks) - 1; idx >= 0; idx-- {
+		if locks[idx].lock == lock && locks[idx].mode == from {
+			locks[idx].mode = to
+			return
+		}
+	}
+}
+
+func (m *Monitor) releaseHeld(gid int64, lock *lockInfo, mode lockMode, now time.Time) bool {
+	locks := m.held[gid]
+	for idx := len(locks) - 1; idx >= 0; idx-- {
+		if locks[idx].lock != lock || locks[idx].mode != mode {
+			continue
+		}
+
+		m.site(locks[idx].site).Hold.Observe(now.Sub(locks[idx].acquiredAt))
+		locks = append(locks[:idx], locks[idx+1:]...)
+		if len(locks) == 0 {
+			delete(m.held, gid)
+		} else {
+			m.held[gid] = locks
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (m *Monitor) addEdge(held heldLock, lock *lockInfo, site, stack string) {
+	edges, found := m.order[held.lock]
+	if !found {
+		edges = make(map[*lockInfo]orderEdge)
+		m.order[held.lock] = edges
+	}
+
+	if _, found := edges[lock]; found {
+		return
+	}
+
+	edges[lock] = orderEdge{site: site, stack: stack}
+	if cycle, last, found := m.path(lock, held.lock); found {
+		names := make([]string, len(cycle))
+		for idx, info := range cycle {
+			names[idx] = info.name
+		}
+
+		m.reports = append(m.reports, Report{
+			Kind:   LockInversion,
+			Locks:  [2]string{held.lock.name, lock.name},
+			Sites:  [2]string{last.site, site},
+			Stacks: [2]string{last.stack, stack},
+			Cycle:  names,
+		})
+	}
+}
+
+// path looks for the shortest earlier acquisition order from one lock
+// to another, it returns locks on the way and the edge into the last one
+func (m *Monitor) path(from, to *lockInfo) ([]*lockInfo, orderEdge, bool) {
+	previous := map[*lockInfo]*lockInfo{from: nil}
+	queue := []*lockInfo{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for next, edge := range m.order[current] {
+			if _, visited := previous[next]; visited {
+				continue
+			}
+
+			previous[next] = current
+			if next != to {
+				queue = append(queue, next)
+				continue
+			}
+
+			var cycle []*lockInfo
+			for info := next; info != nil; info = previous[info] {
+				cycle = append([]*lockInfo{info}, cycle...)
+			}
+
+			return cycle, edge, true
+		}
+	}
+
+	return nil, orderEdge{}, false
+}
+
+func (m *Monitor) site(site string) *SiteStats {
+	stats, found := m.sites[site]
+	if !found {
+		stats = &SiteStats{}
+		m.sites[site] = stats
+	}
+
+	return stats
+}
+
+// DebugMutex is a drop-in replacement for sync.Mutex,
+// zero value reports to the default monitor
+type DebugMutex struct {
+	mutex   sync.Mutex
+	monitor *Monitor
+	info    lockInfo
+	once    sync.Once
+}
+
+func NewDebugMutex(monitor *Monitor, name string) *DebugMutex {
+	return &DebugMutex{monitor: monitor, info: lockInfo{name: name}}
+}
+
+func (m *DebugMutex) Lock() {
+	m.init()
+	site := callerSite()
+	gid, start := m.monitor.acquire(&m.info, site)
+	m.mutex.Lock()
+	m.monitor.acquired(&m.info, writeMode, site, gid, start)
+}
+
+func (m *DebugMutex) TryLock() bool {
+	m.init()
+	if !m.mutex.TryLock() {
+		return false
+	}
+
+	m.monitor.tryAcquired(&m.info, writeMode, callerSite())
+	return true
+}
+
+func (m *DebugMutex) Unlock() {
+	m.init()
+	m.monitor.release(&m.info, writeMode)
+	m.mutex.Unlock()
+}
+
+func (m *DebugMutex) init() {
+	m.once.Do(func() {
+		if m.monitor == nil {
+			m.monitor = defaultMonitor
+		}
+		if m.info.name == "" {
+			m.info.name = fmt.Sprintf("mutex@%p", m)
+		}
+	})
+}
+
+// DebugRWMutex wraps the homework RWMutex with the same instrumentation,
+// upgrades and downgrades change the mode of the held lock
+type DebugRWMutex struct {
+	mutex   *RWMutex
+	monitor *Monitor
+	info    lockInfo
+}
+
+func NewDebugRWMutex(monitor *Monitor, name string, options ...func(*RWMutex)) *DebugRWMutex {
+	if monitor == nil {
+		monitor = defaultMonitor
+	}
+
+	return &DebugRWMutex{
+		mutex:   NewRWMutex(options...),
+		monitor: monitor,
+		info:    lockInfo{name: name},
+	}
+}
+
+func (m *DebugRWMutex) Lock() {
+	_ = m.lock(callerSite(), writeMode, func() error {
+		m.mutex.Lock()
+		return nil
+	})
+}
+
+func (m *DebugRWMutex) TryLock() bool {
+	return m.tryLock(callerSite(), writeMode, m.mutex.TryLock)
+}
+
+func (m *DebugRWMutex) LockContext(ctx context.Context) error {
+	return m.lock(callerSite(), writeMode, func() error {
+		return m.mutex.LockContext(ctx)
+	})
+}
+
+func (m *DebugRWMutex) Unlock() {
+	m.monitor.release(&m.info, writeMode)
+	m.mutex.Unlock()
+}
+
+func (m *DebugRWMutex) RLock() {
+	_ = m.lock(callerSite(), readMode, func() error {
+		m.mutex.RLock()
+		return nil
+	})
+}
+
+func (m *DebugRWMutex) TryRLock() bool {
+	return m.tryLock(callerSite(), readMode, m.mutex.TryRLock)
+}
+
+func (m *DebugRWMutex) RLockContext(ctx context.Context) error {
+	return m.lock(callerSite(), readMode, func() error {
+		return m.mutex.RLockContext(ctx)
+	})
+}
+
+func (m *DebugRWMutex) RUnlock() {
+	m.monitor.release(&m.info, readMode)
+	m.mutex.RUnlock()
+}
+
+func (m *DebugRWMutex) UpgradableRLock() {
+	_ = m.lock(callerSite(), upgradableMode, func() error {
+		m.mutex.UpgradableRLock()
+		return nil
+	})
+}
+
+func (m *DebugRWMutex) UpgradableRUnlock() {
+	m.monitor.release(&m.info, upgradableMode)
+	m.mutex.UpgradableRUnlock()
+}
+
+// Upgrade is released with Unlock like a write lock
+func (m *DebugRWMutex) Upgrade() {
+	site, start := callerSite(), time.Now()
+	m.mutex.Upgrade()
+	m.monitor.convert(&m.info, upgradableMode, writeMode, site, start)
+}
+
+// Downgrade is released with RUnlock like a read lock
+func (m *DebugRWMutex) Downgrade() {
+	site, start := callerSite(), time.Now()
+	m.mutex.Downgrade()
+	m.monitor.convert(&m.info, writeMode, readMode, site, start)
+}
+
+func (m *DebugRWMutex) lock(site string, mode lockMode, lock func() error) error {
+	gid, start := m.monitor.acquire(&m.info, site)
+	if err := lock(); err != nil {
+		return err
+	}
+
+	m.monitor.acquired(&m.info, mode, site, gid, start)
+	return nil
+}
+
+func (m *DebugRWMutex) tryLock(site string, mode lockMode, tryLock func() bool) bool {
+	if !tryLock() {
+		return false
+	}
+
+	m.monitor.tryAcquired(&m.info, mode, site)
+	return true
+}
+
+func callerSite() string {
+	_, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+
+	return file + ":" + strconv.Itoa(line)
+}
+
+func currentStack() string {
+	buffer := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buffer, false)
+		if n < len(buffer) {
+			return string(buffer[:n])
+		}
+
+		buffer = make([]byte, 2*len(buffer))
+	}
+}
+
+func goroutineID() int64 {
+	var buffer [64]byte
+	n := runtime.Stack(buffer[:], false)
+	// first line looks like "goroutine 18 [running]:"
+	fields := bytes.Fields(buffer[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+
+	id, _ := strconv.ParseInt(string(fields[1]), 10, 64)
+	return id
+}
+
+func TestDebugMutexContention(t *testing.T) {
+	monitor := NewMonitor(false)
+	mutex := NewDebugMutex(monitor, "counter")
+
+	mutex.Lock()
+	locked := make(chan struct{})
+	go func() {
+		mutex.Lock() // waits for the first holder
+		mutex.Unlock()
+		close(locked)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	mutex.Unlock()
+	<-locked
+
+	var wait, hold Histogram
+	for _, stats := range monitor.Stats() {
+		wait.Count += stats.Wait.Count
+		wait.Max = max(wait.Max, stats.Wait.Max)
+		hold.Count += stats.Hold.Count
+		hold.Max = max(hold.Max, stats.Hold.Max)
+	}
+
+	assert.Len(t, monitor.Stats(), 2) // two lock sites
+	assert.Equal(t, int64(2), wait.Count)
+	assert.Equal(t, int64(2), hold.Count)
+	assert.GreaterOrEqual(t, wait.Max, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, hold.Max, 50*time.Millisecond)
+	assert.Empty(t, monitor.Reports())
+}
+
+func TestDebugMutexLockInversion(t *testing.T) {
+	monitor := NewMonitor(true)
+	first := NewDebugMutex(monitor, "first")
+	second := NewDebugRWMutex(monitor, "second")
+
+	first.Lock()
+	second.RLock()
+	second.RUnlock()
+	first.Unlock()
+
+	assert.Empty(t, monitor.Reports())
+
+	second.Lock()
+	first.Lock() // opposite order could deadlock with the one above
+	first.Unlock()
+	second.Unlock()
+
+	reports := monitor.Reports()
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, LockInversion, reports[0].Kind)
+		assert.Equal(t, [2]string{"second", "first"}, reports[0].Locks)
+		assert.Equal(t, []string{"first", "second"}, reports[0].Cycle)
+		assert.Less(t, siteLine(reports[0].Sites[0]), siteLine(reports[0].Sites[1])) // earlier goes first
+		assert.NotEmpty(t, reports[0].Stacks[0])
+		assert.NotEmpty(t, reports[0].Stacks[1])
+	}
+}
+
+func siteLine(site string) int {
+	line, _ := strconv.Atoi(site[strings.LastIndex(site, ":")+1:])
+	return line
+}
+
+func TestDebugMutexLockInversionCycle(t *testing.T) {
+	monitor := NewMonitor(true)
+	a := NewDebugMutex(monitor, "a")
+	b := NewDebugMutex(monitor, "b")
+	c := NewDebugRWMutex(monitor, "c")
+
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+
+	b.Lock()
+	_, file, line, _ := runtime.Caller(0)
+	c.Lock() // closes the cycle below
+	c.Unlock()
+	b.Unlock()
+
+	assert.Empty(t, monitor.Reports())
+
+	c.Lock()
+	a.Lock() // a -> b -> c earlier, c -> a now
+	a.Unlock()
+	c.Unlock()
+
+	reports := monitor.Reports()
+	if assert.Len(t, reports, 1) {
+		report := reports[0]
+		assert.Equal(t, LockInversion, report.Kind)
+		assert.Equal(t, [2]string{"c", "a"}, report.Locks)
+		assert.Equal(t, []string{"a", "b", "c"}, report.Cycle)
+		assert.Equal(t, file+":"+strconv.Itoa(line+1), report.Sites[0])
+		assert.Contains(t, report.String(), "earlier a -> b -> c (b -> c at "+report.Sites[0]+"), now c -> a at")
+	}
+}
+
+func TestDebugRWMutexDropIn(t *testing.T) {
+	monitor := NewMonitor(false)
+	mutex := NewDebugRWMutex(monitor, "rw")
+
+	assert.NoError(t, mutex.LockContext(context.Background()))
+	assert.False(t, mutex.TryRLock())
+	mutex.Downgrade()
+	assert.True(t, mutex.TryRLock())
+	mutex.RUnlock()
+	mutex.RUnlock()
+
+	mutex.UpgradableRLock()
+	assert.NoError(t, mutex.RLockContext(context.Background()))
+	mutex.RUnlock()
+	mutex.Upgrade()
+	assert.False(t, mutex.TryLock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, mutex.RLockContext(ctx), context.DeadlineExceeded)
+
+	monitor.mutex.Lock()
+	held := monitor.held[goroutineID()]
+	if assert.Len(t, held, 1) {
+		assert.Equal(t, writeMode, held[0].mode)
+	}
+	monitor.mutex.Unlock()
+
+	mutex.Unlock()
+	assert.True(t, mutex.TryLock())
+	mutex.Unlock()
+
+	mutex.UpgradableRLock()
+	mutex.UpgradableRUnlock()
+
+	var hold int64
+	for _, stats := range monitor.Stats() {
+		hold += stats.Hold.Count
+	}
+
+	assert.Equal(t, int64(6), hold) // downgraded, try read, read, upgraded, try write, upgradable
+	assert.Empty(t, monitor.held)
+	assert.Empty(t, monitor.Reports())
+}
+
+func TestDebugMutexRecursiveLock(t *testing.T) {
+	monitor := NewMonitor(true)
+	mutex := NewDebugMutex(monitor, "recursive")
+
+	relocked := make(chan struct{})
+	go func() {
+		mutex.Lock()
+		mutex.Lock() // blocks forever without help
+		mutex.Unlock()
+		close(relocked)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	reports := monitor.Reports()
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, RecursiveLock, reports[0].Kind)
+		assert.NotEqual(t, reports[0].Sites[0], reports[0].Sites[1])
+		assert.Contains(t, reports[0].Stacks[0], "TestDebugMutexRecursiveLock")
+		assert.Contains(t, reports[0].Stacks[1], "TestDebugMutexRecursiveLock")
+	}
+
+	mutex.Unlock() // unlock from another goroutine to release the test
+	<-relocked
+}
+
+func TestDebugMutexZeroValue(t *testing.T) {
+	var mutex DebugMutex
+	mutex.Lock()
+	assert.False(t, mutex.TryLock())
+	mutex.Unlock()
+	assert.True(t, mutex.TryLock())
+	mutex.Unlock()
+}
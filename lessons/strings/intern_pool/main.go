@@ -0,0 +1,172 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+type entry struct {
+	value   string
+	element *list.Element // not nil while the LRU keeps the entry alive
+}
+
+// Handle is a pointer-sized reference to an interned value,
+// handles of equal values from the same pool are always equal
+type Handle struct {
+	entry *entry
+}
+
+func (h Handle) Value() string {
+	if h.entry == nil {
+		return ""
+	}
+
+	return h.entry.value
+}
+
+func (h Handle) IsZero() bool {
+	return h.entry == nil
+}
+
+type Stats struct {
+	Hits       int64
+	Misses     int64
+	Evictions  int64
+	Entries    int
+	BytesSaved int64 // bytes that weren't allocated thanks to hits
+}
+
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type shard struct {
+	mutex    sync.Mutex
+	entries  map[string]weak.Pointer[entry] // weak pointers don't keep entries alive
+	lru      list.List                      // front is the most recently used entry
+	capacity int
+	stats    Stats
+}
+
+// Pool interns strings, every shard keeps alive a bounded number of recently
+// used entries. When a shard is full the least recently used entry is evicted
+// from the LRU, but it stays in the pool while there are handles to it, so
+// handles stay comparable. It's removed by a cleanup when they're gone
+type Pool struct {
+	seed   maphash.Seed
+	shards []shard
+}
+
+func NewPool(shardsNumber, capacity int) *Pool {
+	shardsNumber = max(1, shardsNumber)
+	shards := make([]shard, shardsNumber)
+	for idx := range shards {
+		shards[idx].entries = make(map[string]weak.Pointer[entry])
+		shards[idx].capacity = max(1, capacity/shardsNumber)
+	}
+
+	return &Pool{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+	}
+}
+
+func (p *Pool) Intern(value string) Handle {
+	s := &p.shards[maphash.String(p.seed, value)%uint64(len(p.shards))]
+	return s.intern(value, func() string { return value })
+}
+
+// InternBytes copies the value only on a miss, the lookup key doesn't
+// escape, so the compiler converts short values on the stack
+func (p *Pool) InternBytes(value []byte) Handle {
+	s := &p.shards[maphash.Bytes(p.seed, value)%uint64(len(p.shards))]
+	return s.intern(string(value), func() string { return string(value) })
+}
+
+func (p *Pool) Stats() Stats {
+	var stats Stats
+	for idx := range p.shards {
+		s := &p.shards[idx]
+		s.mutex.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Evictions += s.stats.Evictions
+		stats.BytesSaved += s.stats.BytesSaved
+		stats.Entries += len(s.entries)
+		s.mutex.Unlock()
+	}
+
+	return stats
+}
+
+// intern keeps clone() instead of the key, so the key can point
+// to a temporary buffer that is reused by the caller
+func (s *shard) intern(key string, clone func() string) Handle {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the entry is nil if it's collected, but the cleanup hasn't run yet
+	if e := s.entries[key].Value(); e != nil {
+		s.use(e)
+		s.stats.Hits++
+		s.stats.BytesSaved += int64(len(key))
+		return Handle{entry: e}
+	}
+
+	s.stats.Misses++
+	e := &entry{value: clone()}
+	runtime.AddCleanup(e, s.remove, e.value)
+	s.entries[e.value] = weak.Make(e)
+	s.use(e)
+	return Handle{entry: e}
+}
+
+// use moves the entry to the front of the LRU and evicts the least
+// recently used entries, which are alive only while there are handles
+func (s *shard) use(e *entry) {
+	if e.element != nil {
+		s.lru.MoveToFront(e.element)
+		return
+	}
+
+	e.element = s.lru.PushFront(e)
+	for s.lru.Len() > s.capacity {
+		oldest := s.lru.Remove(s.lru.Back()).(*entry)
+		oldest.element = nil
+		s.stats.Evictions++
+	}
+}
+
+// remove runs after the entry with the value is collected, the value
+// could be interned again in the meantime, then the new entry stays
+func (s *shard) remove(value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.entries[value].Value() == nil {
+		delete(s.entries, value)
+	}
+}
+
+func main() {
+	pool := NewPool(4, 1024)
+	lines := [][]byte{
+		[]byte("GET"), []byte("POST"), []byte("GET"), []byte("GET"),
+	}
+
+	handles := make([]Handle, 0, len(lines))
+	for _, line := range lines {
+		handles = append(handles, pool.InternBytes(line))
+	}
+
+	fmt.Println(handles[0] == handles[2], handles[0] == handles[1])
+	fmt.Printf("%+v %.2f\n", pool.Stats(), pool.Stats().HitRate())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. .
+
+func TestPoolIntern(t *testing.T) {
+	pool := NewPool(4, 100)
+
+	first := pool.Intern("value")
+	second := pool.InternBytes([]byte("value"))
+	other := pool.Intern("other")
+
+	assert.True(t, first == second)
+	assert.False(t, first == other)
+	assert.Equal(t, "value", second.Value())
+	assert.True(t, Handle{}.IsZero())
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, int64(len("value")), stats.BytesSaved)
+	assert.Equal(t, 2, stats.Entries)
+	assert.InDelta(t, 1.0/3.0, stats.HitRate(), 0.001)
+}
+
+func TestPoolEviction(t *testing.T) {
+	pool := NewPool(1, 2)
+
+	first := pool.Intern("first")
+	pool.Intern("second")
+	pool.Intern("first") // second becomes the least recently used
+	pool.Intern("third")
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.True(t, first == pool.Intern("first"))
+
+	// nothing refers to second, it's removed after garbage collection
+	waitEntries(t, pool, 2)
+
+	pool.Intern("second")
+	assert.Equal(t, int64(2), pool.Stats().Evictions)
+	assert.Equal(t, int64(4), pool.Stats().Misses)
+	assert.Equal(t, "first", first.Value())
+}
+
+func TestPoolEvictionKeepsLiveHandles(t *testing.T) {
+	pool := NewPool(1, 1)
+
+	first := pool.Intern("first")
+	for idx := 0; idx < 10; idx++ {
+		pool.Intern(strconv.Itoa(idx)) // evicts first from the LRU
+	}
+
+	waitEntries(t, pool, 2) // first has a handle, the last value is in the LRU
+
+	// handles compare as values even after eviction
+	again := pool.Intern("first")
+	assert.True(t, first == again)
+	assert.Equal(t, int64(1), pool.Stats().Hits)
+	runtime.KeepAlive(first)
+}
+
+func TestPoolZeroShards(t *testing.T) {
+	pool := NewPool(0, 10)
+	assert.True(t, pool.Intern("value") == pool.InternBytes([]byte("value")))
+	assert.Len(t, pool.shards, 1)
+}
+
+// waitEntries collects garbage until cleanups remove unused entries
+func waitEntries(t *testing.T, pool *Pool, entries int) {
+	t.Helper()
+
+	for i := 0; i < 20; i++ {
+		runtime.GC()
+		if pool.Stats().Entries == entries {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("pool has %d entries instead of %d", pool.Stats().Entries, entries)
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	pool := NewPool(8, 1000)
+
+	handles := make([][]Handle, 8)
+	wg := sync.WaitGroup{}
+	wg.Add(len(handles))
+	for idx := range handles {
+		go func(idx int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				handles[idx] = append(handles[idx], pool.InternBytes([]byte(strconv.Itoa(i))))
+			}
+		}(idx)
+	}
+
+	wg.Wait()
+	for idx := range handles {
+		assert.Equal(t, handles[0], handles[idx])
+	}
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(100), stats.Misses)
+	assert.Equal(t, int64(700), stats.Hits)
+}
+
+func TestPoolInternBytesAllocations(t *testing.T) {
+	pool := NewPool(1, 10)
+	value := []byte("value")
+	pool.InternBytes(value)
+
+	allocations := testing.AllocsPerRun(100, func() {
+		pool.InternBytes(value)
+	})
+	assert.Zero(t, allocations)
+}
+
+var fields = [][]byte{[]byte("INFO"), []byte("WARN"), []byte("ERROR"), []byte("service-a"), []byte("service-b")}
+
+var Result []string
+
+func BenchmarkStringConversion(b *testing.B) {
+	values := make([]string, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		values = append(values, string(fields[i%len(fields)]))
+	}
+	Result = values
+}
+
+func BenchmarkPoolInternBytes(b *testing.B) {
+	pool := NewPool(4, 1024)
+	values := make([]string, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		values = append(values, pool.InternBytes(fields[i%len(fields)]).Value())
+	}
+	Result = values
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+const maxLeafSize = 256
+
+// node is never changed after creation, so ropes share
+// subtrees and every old version stays valid
+type node struct {
+	left   *node
+	right  *node
+	leaf   []byte
+	length int
+	lines  int // number of '\n' in the subtree
+	height int
+}
+
+func newLeaf(data []byte) *node {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return &node{
+		leaf:   data[:len(data):len(data)],
+		length: len(data),
+		lines:  bytes.Count(data, []byte{'\n'}),
+	}
+}
+
+func newNode(left, right *node) *node {
+	return &node{
+		left:   left,
+		right:  right,
+		length: left.length + right.length,
+		lines:  left.lines + right.lines,
+		height: max(left.height, right.height) + 1,
+	}
+}
+
+func (n *node) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+func height(n *node) int {
+	if n == nil {
+		return -1
+	}
+
+	return n.height
+}
+
+// join concatenates trees like AVL join: the smaller tree
+// goes down along the spine of the bigger one - O(log n)
+func join(left, right *node) *node {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.isLeaf() && right.isLeaf() && left.length+right.length <= maxLeafSize:
+		data := make([]byte, 0, left.length+right.length)
+		data = append(data, left.leaf...)
+		data = append(data, right.leaf...)
+		return newLeaf(data)
+	case left.height > right.height+1:
+		return balance(left.left, join(left.right, right))
+	case right.height > left.height+1:
+		return balance(join(left, right.left), right.right)
+	default:
+		return newNode(left, right)
+	}
+}
+
+// balance makes a node from subtrees with heights that differ at most by 2
+func balance(left, right *node) *node {
+	switch {
+	case height(left) > height(right)+1:
+		if height(left.left) >= height(left.right) {
+			return newNode(left.left, newNode(left.right, right))
+		}
+		return newNode(newNode(left.left, left.right.left), newNode(left.right.right, right))
+	case height(right) > height(left)+1:
+		if height(right.right) >= height(right.left) {
+			return newNode(newNode(left, right.left), right.right)
+		}
+		return newNode(newNode(left, right.left.left), newNode(right.left.right, right.right))
+	default:
+		return newNode(left, right)
+	}
+}
+
+func split(n *node, offset int) (*node, *node) {
+	switch {
+	case n == nil:
+		return nil, nil
+	case offset <= 0:
+		return nil, n
+	case offset >= n.length:
+		return n, nil
+	case n.isLeaf():
+		return newLeaf(n.leaf[:offset]), newLeaf(n.leaf[offset:])
+	case offset < n.left.length:
+		left, right := split(n.left, offset)
+		return left, join(right, n.right)
+	case offset == n.left.length:
+		return n.left, n.right
+	default:
+		left, right := split(n.right, offset-n.left.length)
+		return join(n.left, left), right
+	}
+}
+
+// Rope is an immutable text, every edit returns a new rope
+// that shares unchanged chunks with the previous one
+type Rope struct {
+	root *node
+}
+
+func New(text string) Rope {
+	leaves := make([]*node, 0, len(text)/maxLeafSize+1)
+	for start := 0; start < len(text); start += maxLeafSize {
+		end := min(start+maxLeafSize, len(text))
+		leaves = append(leaves, newLeaf([]byte(text[start:end])))
+	}
+
+	return Rope{root: build(leaves)}
+}
+
+func build(leaves []*node) *node {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		return leaves[0]
+	default:
+		middle := len(leaves) / 2
+		return newNode(build(leaves[:middle]), build(leaves[middle:]))
+	}
+}
+
+func (r Rope) Len() int {
+	if r.root == nil {
+		return 0
+	}
+
+	return r.root.length
+}
+
+func (r Rope) Index(offset int) (byte, bool) {
+	if offset < 0 || offset >= r.Len() {
+		return 0, false
+	}
+
+	leaf, start := r.leafAt(offset)
+	return leaf[offset-start], true
+}
+
+func (r Rope) Insert(offset int, text string) (Rope, bool) {
+	if offset < 0 || offset > r.Len() {
+		return r, false
+	}
+
+	left, right := split(r.root, offset)
+	return Rope{root: join(join(left, New(text).root), right)}, true
+}
+
+func (r Rope) Delete(start, end int) (Rope, bool) {
+	if start < 0 || end > r.Len() || start > end {
+		return r, false
+	}
+
+	left, rest := split(r.root, start)
+	_, right := split(rest, end-start)
+	return Rope{root: join(left, right)}, true
+}
+
+func (r Rope) Slice(start, end int) (Rope, bool) {
+	if start < 0 || end > r.Len() || start > end {
+		return Rope{}, false
+	}
+
+	_, rest := split(r.root, start)
+	middle, _ := split(rest, end-start)
+	return Rope{root: middle}, true
+}
+
+func (r Rope) Concat(other Rope) Rope {
+	return Rope{root: join(r.root, other.root)}
+}
+
+// LineOffset returns the offset of the first byte of the line,
+// lines are numbered from zero
+func (r Rope) LineOffset(line int) (int, bool) {
+	if line == 0 {
+		return 0, true
+	}
+
+	if r.root == nil || line < 0 || line > r.root.lines {
+		return 0, false
+	}
+
+	// find the offset after line-th '\n'
+	offset := 0
+	n := r.root
+	for !n.isLeaf() {
+		if n.left.lines >= line {
+			n = n.left
+		} else {
+			line -= n.left.lines
+			offset += n.left.length
+			n = n.right
+		}
+	}
+
+	for idx, symbol := range n.leaf {
+		if symbol == '\n' {
+			line--
+			if line == 0 {
+				return offset + idx + 1, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Position returns the line and the column of the offset
+func (r Rope) Position(offset int) (int, int, bool) {
+	if offset < 0 || offset > r.Len() {
+		return 0, 0, false
+	}
+
+	line := 0
+	n := r.root
+	rest := offset
+	for n != nil && !n.isLeaf() {
+		if rest < n.left.length {
+			n = n.left
+		} else {
+			line += n.left.lines
+			rest -= n.left.length
+			n = n.right
+		}
+	}
+
+	if n != nil {
+		line += bytes.Count(n.leaf[:rest], []byte{'\n'})
+	}
+
+	lineOffset, _ := r.LineOffset(line)
+	return line, offset - lineOffset, true
+}
+
+func (r Rope) String() string {
+	var buffer bytes.Buffer
+	buffer.Grow(r.Len())
+	_, _ = r.WriteTo(&buffer)
+	return buffer.String()
+}
+
+func (r Rope) WriteTo(writer io.Writer) (int64, error) {
+	var written int64
+	err := walk(r.root, func(leaf []byte) error {
+		n, err := writer.Write(leaf)
+		written += int64(n)
+		return err
+	})
+
+	return written, err
+}
+
+func (r Rope) leafAt(offset int) ([]byte, int) {
+	start := 0
+	n := r.root
+	for !n.isLeaf() {
+		if offset-start < n.left.length {
+			n = n.left
+		} else {
+			start += n.left.length
+			n = n.right
+		}
+	}
+
+	return n.leaf, start
+}
+
+func walk(n *node, action func(leaf []byte) error) error {
+	if n == nil {
+		return nil
+	}
+
+	if n.isLeaf() {
+		return action(n.leaf)
+	}
+
+	if err := walk(n.left, action); err != nil {
+		return err
+	}
+
+	return walk(n.right, action)
+}
+
+type Reader struct {
+	rope   Rope
+	offset int
+}
+
+func NewReader(rope Rope) *Reader {
+	return &Reader{rope: rope}
+}
+
+func (r *Reader) Read(buffer []byte) (int, error) {
+	if r.offset >= r.rope.Len() {
+		return 0, io.EOF
+	}
+
+	read := 0
+	for read < len(buffer) && r.offset < r.rope.Len() {
+		leaf, start := r.rope.leafAt(r.offset)
+		n := copy(buffer[read:], leaf[r.offset-start:])
+		read += n
+		r.offset += n
+	}
+
+	return read, nil
+}
+
+func (r *Reader) WriteTo(writer io.Writer) (int64, error) {
+	rest, _ := r.rope.Slice(r.offset, r.rope.Len())
+	r.offset = r.rope.Len()
+	return rest.WriteTo(writer)
+}
+
+func main() {
+	history := []Rope{New("Hello world\n")}
+
+	current, _ := history[len(history)-1].Insert(5, ",")
+	history = append(history, current)
+
+	current, _ = current.Insert(current.Len(), "Second line\n")
+	history = append(history, current)
+
+	_, _ = current.WriteTo(os.Stdout)
+
+	// undo is just a previous snapshot
+	fmt.Print(history[len(history)-2])
+}
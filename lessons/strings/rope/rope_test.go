@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkBalance(t *testing.T, n *node) {
+	if n == nil || n.isLeaf() {
+		return
+	}
+
+	assert.LessOrEqual(t, abs(n.left.height-n.right.height), 1)
+	checkBalance(t, n.left)
+	checkBalance(t, n.right)
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+func randomText(random *rand.Rand, length int) string {
+	const alphabet = "abcdef\n"
+	text := make([]byte, length)
+	for idx := range text {
+		text[idx] = alphabet[random.Intn(len(alphabet))]
+	}
+
+	return string(text)
+}
+
+func TestRopeRandomEdits(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	expected := randomText(random, 5000)
+	rope := New(expected)
+
+	for i := 0; i < 1000; i++ {
+		switch random.Intn(3) {
+		case 0:
+			offset := random.Intn(len(expected) + 1)
+			text := randomText(random, random.Intn(600))
+			rope, _ = rope.Insert(offset, text)
+			expected = expected[:offset] + text + expected[offset:]
+		case 1:
+			start := random.Intn(len(expected) + 1)
+			end := start + random.Intn(len(expected)-start+1)
+			rope, _ = rope.Delete(start, end)
+			expected = expected[:start] + expected[end:]
+		case 2:
+			start := random.Intn(len(expected) + 1)
+			end := start + random.Intn(len(expected)-start+1)
+			slice, _ := rope.Slice(start, end)
+			assert.Equal(t, expected[start:end], slice.String())
+		}
+
+		assert.Equal(t, len(expected), rope.Len())
+	}
+
+	assert.Equal(t, expected, rope.String())
+	checkBalance(t, rope.root)
+
+	for i := 0; i < 100; i++ {
+		offset := random.Intn(len(expected))
+		value, ok := rope.Index(offset)
+		assert.True(t, ok)
+		assert.Equal(t, expected[offset], value)
+	}
+
+	_, ok := rope.Index(len(expected))
+	assert.False(t, ok)
+}
+
+func TestRopeConcat(t *testing.T) {
+	left := New(strings.Repeat("a", 10000))
+	right := New("b")
+
+	rope := left.Concat(right).Concat(left)
+	assert.Equal(t, 20001, rope.Len())
+	assert.Equal(t, strings.Repeat("a", 10000)+"b"+strings.Repeat("a", 10000), rope.String())
+	checkBalance(t, rope.root)
+}
+
+func TestRopeSnapshots(t *testing.T) {
+	original := New("immutable")
+	edited, ok := original.Insert(0, "not ")
+	assert.True(t, ok)
+
+	_, ok = original.Insert(100, "x")
+	assert.False(t, ok)
+	_, ok = original.Delete(3, 2)
+	assert.False(t, ok)
+
+	assert.Equal(t, "immutable", original.String())
+	assert.Equal(t, "not immutable", edited.String())
+}
+
+func TestRopeLines(t *testing.T) {
+	lines := make([]string, 500)
+	for idx := range lines {
+		lines[idx] = strings.Repeat("x", idx%37)
+	}
+
+	text := strings.Join(lines, "\n")
+	rope := New(text)
+
+	offset := 0
+	for idx, line := range lines {
+		lineOffset, ok := rope.LineOffset(idx)
+		assert.True(t, ok)
+		assert.Equal(t, offset, lineOffset)
+
+		lineNumber, column, ok := rope.Position(offset + len(line)/2)
+		assert.True(t, ok)
+		assert.Equal(t, idx, lineNumber)
+		assert.Equal(t, len(line)/2, column)
+
+		offset += len(line) + 1
+	}
+
+	_, ok := rope.LineOffset(len(lines))
+	assert.False(t, ok)
+}
+
+func TestRopeReader(t *testing.T) {
+	text := randomText(rand.New(rand.NewSource(2)), 3000)
+	rope := New(text)
+
+	assert.NoError(t, iotest.TestReader(NewReader(rope), []byte(text)))
+
+	reader := NewReader(rope)
+	prefix := make([]byte, 100)
+	_, err := io.ReadFull(reader, prefix)
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+	written, err := reader.WriteTo(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(text)-100), written)
+	assert.Equal(t, text, string(prefix)+buffer.String())
+}
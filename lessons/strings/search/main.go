@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrEmptyPattern = errors.New("search: empty pattern")
+
+// minHorspoolLength is the length from which bad character
+// shifts of Boyer-Moore-Horspool beat the linear scan of KMP
+const minHorspoolLength = 4
+
+type Match struct {
+	Pattern int   // index of the pattern
+	Offset  int64 // offset of the first byte of the match
+}
+
+// state is the position of one search in a stream, so matches
+// that cross chunk boundaries are found as well
+type state interface {
+	feed(chunk []byte, report func(Match) bool) bool
+}
+
+type Matcher struct {
+	algorithm string
+	newState  func() state
+}
+
+// New chooses KMP or Boyer-Moore-Horspool for one pattern
+// and Aho-Corasick for a set of patterns
+func New(patterns ...string) (*Matcher, error) {
+	if len(patterns) == 0 {
+		return nil, ErrEmptyPattern
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return nil, ErrEmptyPattern
+		}
+	}
+
+	if len(patterns) > 1 {
+		automaton := newAhoCorasick(patterns)
+		return &Matcher{algorithm: "Aho-Corasick", newState: func() state {
+			return &ahoCorasickState{automaton: automaton}
+		}}, nil
+	}
+
+	pattern := []byte(patterns[0])
+	if len(pattern) >= minHorspoolLength {
+		skip := horspoolTable(pattern)
+		return &Matcher{algorithm: "Boyer-Moore-Horspool", newState: func() state {
+			return &horspoolState{pattern: pattern, skip: skip}
+		}}, nil
+	}
+
+	failure := kmpTable(pattern)
+	return &Matcher{algorithm: "KMP", newState: func() state {
+		return &kmpState{pattern: pattern, failure: failure}
+	}}, nil
+}
+
+func (m *Matcher) Algorithm() string {
+	return m.algorithm
+}
+
+func (m *Matcher) FindAll(text []byte) []Match {
+	var matches []Match
+	m.newState().feed(text, func(match Match) bool {
+		matches = append(matches, match)
+		return true
+	})
+
+	return matches
+}
+
+// Scan reports every match in the reader in order of their ends,
+// scanning stops when report returns false
+func (m *Matcher) Scan(reader io.Reader, report func(Match) bool) error {
+	s := m.newState()
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 && !s.feed(buffer[:n], report) {
+			return nil
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// kmpTable returns the length of the longest proper
+// prefix that is also a suffix for every prefix
+func kmpTable(pattern []byte) []int {
+	failure := make([]int, len(pattern))
+	for idx, length := 1, 0; idx < len(pattern); idx++ {
+		for length > 0 && pattern[idx] != pattern[length] {
+			length = failure[length-1]
+		}
+
+		if pattern[idx] == pattern[length] {
+			length++
+		}
+
+		failure[idx] = length
+	}
+
+	return failure
+}
+
+type kmpState struct {
+	pattern []byte
+	failure []int
+	matched int
+	offset  int64
+}
+
+func (s *kmpState) feed(chunk []byte, report func(Match) bool) bool {
+	for idx, symbol := range chunk {
+		for s.matched > 0 && s.pattern[s.matched] != symbol {
+			s.matched = s.failure[s.matched-1]
+		}
+
+		if s.pattern[s.matched] == symbol {
+			s.matched++
+		}
+
+		if s.matched == len(s.pattern) {
+			s.matched = s.failure[s.matched-1]
+			if !report(Match{Offset: s.offset + int64(idx+1-len(s.pattern))}) {
+				return false
+			}
+		}
+	}
+
+	s.offset += int64(len(chunk))
+	return true
+}
+
+func horspoolTable(pattern []byte) *[256]int {
+	var skip [256]int
+	for idx := range skip {
+		skip[idx] = len(pattern)
+	}
+
+	for idx := 0; idx < len(pattern)-1; idx++ {
+		skip[pattern[idx]] = len(pattern) - 1 - idx
+	}
+
+	return &skip
+}
+
+type horspoolState struct {
+	pattern []byte
+	skip    *[256]int
+	tail    []byte // the last len(pattern)-1 bytes of the previous chunks
+	offset  int64  // offset of the tail in the stream
+}
+
+func (s *horspoolState) feed(chunk []byte, report func(Match) bool) bool {
+	window := append(s.tail, chunk...)
+	last := len(s.pattern) - 1
+
+	idx := 0
+	for idx+len(s.pattern) <= len(window) {
+		position := last
+		for position >= 0 && window[idx+position] == s.pattern[position] {
+			position--
+		}
+
+		if position < 0 && !report(Match{Offset: s.offset + int64(idx)}) {
+			return false
+		}
+
+		idx += s.skip[window[idx+last]]
+	}
+
+	// matches that start in the tail end in the next chunks
+	keep := min(last, len(window))
+	s.offset += int64(len(window) - keep)
+	s.tail = append(window[:0], window[len(window)-keep:]...)
+	return true
+}
+
+type ahoCorasickNode struct {
+	next    map[byte]int
+	fail    int
+	output  int   // the nearest node by fail links with patterns, -1 if none
+	pattern []int // patterns that end in the node
+}
+
+type ahoCorasick struct {
+	nodes   []ahoCorasickNode
+	lengths []int
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	a := &ahoCorasick{
+		nodes:   []ahoCorasickNode{{next: make(map[byte]int), output: -1}},
+		lengths: make([]int, len(patterns)),
+	}
+
+	for idx, pattern := range patterns {
+		a.lengths[idx] = len(pattern)
+
+		current := 0
+		for position := 0; position < len(pattern); position++ {
+			next, found := a.nodes[current].next[pattern[position]]
+			if !found {
+				next = len(a.nodes)
+				a.nodes = append(a.nodes, ahoCorasickNode{next: make(map[byte]int), output: -1})
+				a.nodes[current].next[pattern[position]] = next
+			}
+			current = next
+		}
+
+		a.nodes[current].pattern = append(a.nodes[current].pattern, idx)
+	}
+
+	// fail links are built breadth-first, so fail links of
+	// shorter prefixes are ready before the longer ones
+	queue := make([]int, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for symbol, child := range a.nodes[current].next {
+			a.nodes[child].fail = a.step(a.nodes[current].fail, symbol)
+
+			fail := a.nodes[child].fail
+			if len(a.nodes[fail].pattern) > 0 {
+				a.nodes[child].output = fail
+			} else {
+				a.nodes[child].output = a.nodes[fail].output
+			}
+
+			queue = append(queue, child)
+		}
+	}
+
+	return a
+}
+
+func (a *ahoCorasick) step(current int, symbol byte) int {
+	for {
+		if next, found := a.nodes[current].next[symbol]; found {
+			return next
+		}
+
+		if current == 0 {
+			return 0
+		}
+
+		current = a.nodes[current].fail
+	}
+}
+
+type ahoCorasickState struct {
+	automaton *ahoCorasick
+	current   int
+	offset    int64
+}
+
+func (s *ahoCorasickState) feed(chunk []byte, report func(Match) bool) bool {
+	a := s.automaton
+	for idx, symbol := range chunk {
+		s.current = a.step(s.current, symbol)
+
+		end := s.offset + int64(idx+1)
+		for node := s.current; node >= 0; node = a.nodes[node].output {
+			for _, pattern := range a.nodes[node].pattern {
+				if !report(Match{Pattern: pattern, Offset: end - int64(a.lengths[pattern])}) {
+					return false
+				}
+			}
+		}
+	}
+
+	s.offset += int64(len(chunk))
+	return true
+}
+
+func main() {
+	matcher, _ := New("he", "she", "his", "hers")
+	fmt.Println(matcher.Algorithm())
+
+	_ = matcher.Scan(strings.NewReader("ushers"), func(match Match) bool {
+		fmt.Printf("pattern %d at offset %d\n", match.Pattern, match.Offset)
+		return true
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. .
+
+func naiveSearch(text string, patterns []string) []Match {
+	var matches []Match
+	for idx, pattern := range patterns {
+		for offset := 0; offset+len(pattern) <= len(text); offset++ {
+			if text[offset:offset+len(pattern)] == pattern {
+				matches = append(matches, Match{Pattern: idx, Offset: int64(offset)})
+			}
+		}
+	}
+
+	sortMatches(matches)
+	return matches
+}
+
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Offset != matches[j].Offset {
+			return matches[i].Offset < matches[j].Offset
+		}
+		return matches[i].Pattern < matches[j].Pattern
+	})
+}
+
+func randomString(random *rand.Rand, length int) string {
+	const alphabet = "abc"
+	data := make([]byte, length)
+	for idx := range data {
+		data[idx] = alphabet[random.Intn(len(alphabet))]
+	}
+
+	return string(data)
+}
+
+func scanAll(t *testing.T, matcher *Matcher, text string, chunkSize int) []Match {
+	var matches []Match
+	reader := iotest.HalfReader(strings.NewReader(text))
+	if chunkSize == 1 {
+		reader = iotest.OneByteReader(strings.NewReader(text))
+	}
+
+	err := matcher.Scan(reader, func(match Match) bool {
+		matches = append(matches, match)
+		return true
+	})
+	assert.NoError(t, err)
+
+	sortMatches(matches)
+	return matches
+}
+
+func TestAlgorithmChoice(t *testing.T) {
+	matcher, err := New("ab")
+	assert.NoError(t, err)
+	assert.Equal(t, "KMP", matcher.Algorithm())
+
+	matcher, _ = New("abcd")
+	assert.Equal(t, "Boyer-Moore-Horspool", matcher.Algorithm())
+
+	matcher, _ = New("ab", "cd")
+	assert.Equal(t, "Aho-Corasick", matcher.Algorithm())
+
+	_, err = New()
+	assert.ErrorIs(t, err, ErrEmptyPattern)
+	_, err = New("a", "")
+	assert.ErrorIs(t, err, ErrEmptyPattern)
+}
+
+func TestMatchersAgainstNaiveSearch(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		text := randomString(random, random.Intn(300))
+		patterns := make([]string, 1+random.Intn(3)*random.Intn(4))
+		for idx := range patterns {
+			patterns[idx] = randomString(random, 1+random.Intn(6))
+		}
+
+		matcher, err := New(patterns...)
+		assert.NoError(t, err)
+
+		expected := naiveSearch(text, patterns)
+		found := matcher.FindAll([]byte(text))
+		sortMatches(found)
+
+		assert.Equal(t, expected, found, "%s: %q in %q", matcher.Algorithm(), patterns, text)
+		assert.Equal(t, expected, scanAll(t, matcher, text, 1), matcher.Algorithm())
+		assert.Equal(t, expected, scanAll(t, matcher, text, 0), matcher.Algorithm())
+	}
+}
+
+func TestAhoCorasickClassic(t *testing.T) {
+	matcher, _ := New("he", "she", "his", "hers")
+	matches := matcher.FindAll([]byte("ushers"))
+
+	assert.Equal(t, []Match{
+		{Pattern: 1, Offset: 1},
+		{Pattern: 0, Offset: 2},
+		{Pattern: 3, Offset: 2},
+	}, matches)
+}
+
+func TestScanStopAndErrors(t *testing.T) {
+	matcher, _ := New("aa")
+
+	count := 0
+	err := matcher.Scan(strings.NewReader("aaaaaa"), func(Match) bool {
+		count++
+		return count < 2
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	readErr := errors.New("read error")
+	err = matcher.Scan(iotest.ErrReader(readErr), func(Match) bool { return true })
+	assert.ErrorIs(t, err, readErr)
+}
+
+func BenchmarkManyKeywords(b *testing.B) {
+	random := rand.New(rand.NewSource(1))
+	keywords := make([]string, 1000)
+	for idx := range keywords {
+		keywords[idx] = "keyword" + strconv.Itoa(idx)
+	}
+
+	var text bytes.Buffer
+	for text.Len() < 1<<20 {
+		text.WriteString(randomString(random, 50))
+		text.WriteString(keywords[random.Intn(len(keywords))])
+	}
+
+	b.Run("AhoCorasick", func(b *testing.B) {
+		matcher, _ := New(keywords...)
+		b.SetBytes(int64(text.Len()))
+		for i := 0; i < b.N; i++ {
+			_ = matcher.Scan(bytes.NewReader(text.Bytes()), func(Match) bool { return true })
+		}
+	})
+
+	b.Run("StringsIndex", func(b *testing.B) {
+		data := text.String()
+		b.SetBytes(int64(text.Len()))
+		for i := 0; i < b.N; i++ {
+			for _, keyword := range keywords {
+				for rest := data; ; {
+					idx := strings.Index(rest, keyword)
+					if idx < 0 {
+						break
+					}
+					rest = rest[idx+1:]
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. .
+
+func TestBuilderUTF8(t *testing.T) {
+	builder := NewBuilder()
+
+	size, err := builder.WriteRune('ж')
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	size, err = builder.WriteString("😀!")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, size)
+
+	size, _ = builder.WriteRune(-1) // invalid rune is written as U+FFFD
+	assert.Equal(t, 3, size)
+
+	assert.Equal(t, "ж😀!�", builder.String())
+	assert.NoError(t, builder.Validate())
+
+	_ = builder.WriteByte(0xF0) // starts a 4-byte sequence
+	_ = builder.WriteByte('a')
+
+	var invalid *InvalidUTF8Error
+	assert.ErrorAs(t, builder.Validate(), &invalid)
+	assert.Equal(t, 10, invalid.Offset)
+}
+
+func TestBuilderGrow(t *testing.T) {
+	builder := NewBuilder()
+	_, _ = builder.WriteString("abcd")
+
+	builder.Grow(2) // doesn't shrink the buffer
+	assert.Equal(t, "abcd", builder.String())
+
+	builder.Grow(100)
+	assert.GreaterOrEqual(t, builder.Cap(), 104)
+
+	capacity := builder.Cap()
+	_, _ = builder.Write(make([]byte, 100))
+	assert.Equal(t, capacity, builder.Cap())
+	assert.Equal(t, 104, builder.Len())
+
+	assert.Panics(t, func() { builder.Grow(-1) })
+}
+
+func TestBuilderZeroCopyString(t *testing.T) {
+	builder := NewBuilder()
+	_, _ = builder.WriteString("hello")
+
+	first := builder.String()
+	assert.Equal(t, unsafe.SliceData(builder.buffer), unsafe.StringData(first))
+
+	_, _ = builder.WriteString(", world")
+	assert.Equal(t, "hello", first)
+	assert.Equal(t, "hello, world", builder.String())
+
+	value, ok := builder.At(4)
+	assert.True(t, ok)
+	assert.Equal(t, byte('o'), value)
+	_, ok = builder.At(12)
+	assert.False(t, ok)
+}
+
+func TestBuilderCopyDetection(t *testing.T) {
+	var builder Builder
+	copied := builder
+	_ = copied.WriteByte('a') // zero builder can be copied
+
+	_ = builder.WriteByte('a')
+	copied = builder
+	assert.Panics(t, func() {
+		_ = copied.WriteByte('b')
+	})
+}
+
+func TestBuilderResetAndPool(t *testing.T) {
+	builder := GetBuilder()
+	_, _ = builder.WriteString("reused")
+	buffer := unsafe.SliceData(builder.buffer)
+
+	builder.Reset() // String wasn't called, the buffer is reused
+	_, _ = builder.WriteString("again")
+	assert.Equal(t, buffer, unsafe.SliceData(builder.buffer))
+
+	result := builder.String()
+	PutBuilder(builder)
+
+	builder = GetBuilder()
+	_, _ = builder.WriteString("other")
+	assert.Equal(t, "again", result) // strings survive the reuse
+	PutBuilder(builder)
+}
+
+func BenchmarkConcatenationWithOperatorPlus(b *testing.B) {
+	s0 := "str1"
+	s1 := "str2"
+	s2 := "str3"
+	s3 := "str4"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s0 + s1 + s2 + s3
+	}
+}
+
+func BenchmarkConcatenationWithStringsBuilder(b *testing.B) {
+	s0 := "str1"
+	s1 := "str2"
+	s2 := "str3"
+	s3 := "str4"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		builder := strings.Builder{}
+		builder.Grow(16)
+		builder.WriteString(s0)
+		builder.WriteString(s1)
+		builder.WriteString(s2)
+		builder.WriteString(s3)
+		_ = builder.String()
+	}
+}
+
+func BenchmarkConcatenationWithBuilder(b *testing.B) {
+	s0 := "str1"
+	s1 := "str2"
+	s2 := "str3"
+	s3 := "str4"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		builder := NewBuilder()
+		builder.Grow(16)
+		_, _ = builder.WriteString(s0)
+		_, _ = builder.WriteString(s1)
+		_, _ = builder.WriteString(s2)
+		_, _ = builder.WriteString(s3)
+		_ = builder.String()
+	}
+}
+
+func BenchmarkConcatenationWithPooledBuilder(b *testing.B) {
+	s0 := "str1"
+	s1 := "str2"
+	s2 := "str3"
+	s3 := "str4"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		builder := GetBuilder()
+		_, _ = builder.WriteString(s0)
+		_, _ = builder.WriteString(s1)
+		_, _ = builder.WriteString(s2)
+		_, _ = builder.WriteString(s3)
+		_ = builder.String() // the buffer becomes shared and isn't reused
+		PutBuilder(builder)
+	}
+}
@@ -1,53 +1,178 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
 
+type InvalidUTF8Error struct {
+	Offset int
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("invalid utf-8 sequence at offset %d", e.Offset)
+}
+
+var (
+	_ io.Writer       = (*Builder)(nil)
+	_ io.ByteWriter   = (*Builder)(nil)
+	_ io.StringWriter = (*Builder)(nil)
+)
+
+// Builder must not be copied after the first write, the copy
+// would share the buffer with the strings of the original
 type Builder struct {
+	addr   *Builder // to detect copying by value
 	buffer []byte
+	shared bool // the buffer is referenced by the result of String
 }
 
 func NewBuilder() Builder {
 	return Builder{}
 }
 
-func (b *Builder) Grow(capacity int) {
-	if capacity < 0 {
-		return
+func (b *Builder) copyCheck() {
+	if b.addr == nil {
+		b.addr = b
+	} else if b.addr != b {
+		panic("illegal use of non-zero Builder copied by value")
 	}
+}
 
-	if capacity < len(b.buffer) {
-		b.buffer = b.buffer[:capacity]
-		return
+// Grow guarantees space for another n bytes without reallocation
+func (b *Builder) Grow(n int) {
+	b.copyCheck()
+	if n < 0 {
+		panic("negative count")
 	}
 
-	buffer := make([]byte, len(b.buffer), capacity)
-	copy(buffer, b.buffer)
-	b.buffer = buffer
+	if cap(b.buffer)-len(b.buffer) < n {
+		buffer := make([]byte, len(b.buffer), 2*cap(b.buffer)+n)
+		copy(buffer, b.buffer)
+		b.buffer = buffer
+	}
 }
 
-func (b *Builder) Write(symbol byte) {
+func (b *Builder) Write(data []byte) (int, error) {
+	b.copyCheck()
+	b.buffer = append(b.buffer, data...)
+	return len(data), nil
+}
+
+func (b *Builder) WriteByte(symbol byte) error {
+	b.copyCheck()
 	b.buffer = append(b.buffer, symbol)
+	return nil
 }
 
-func (b *Builder) At(index int) *byte {
+func (b *Builder) WriteRune(symbol rune) (int, error) {
+	b.copyCheck()
+	length := len(b.buffer)
+	b.buffer = utf8.AppendRune(b.buffer, symbol)
+	return len(b.buffer) - length, nil
+}
+
+func (b *Builder) WriteString(str string) (int, error) {
+	b.copyCheck()
+	b.buffer = append(b.buffer, str...)
+	return len(str), nil
+}
+
+// Validate returns *InvalidUTF8Error with the offset of the
+// first invalid sequence, WriteByte can split multi-byte runes
+func (b *Builder) Validate() error {
+	for offset := 0; offset < len(b.buffer); {
+		symbol, size := utf8.DecodeRune(b.buffer[offset:])
+		if symbol == utf8.RuneError && size <= 1 {
+			return &InvalidUTF8Error{Offset: offset}
+		}
+
+		offset += size
+	}
+
+	return nil
+}
+
+func (b *Builder) At(index int) (byte, bool) {
 	if index < 0 || index >= len(b.buffer) {
-		return nil
+		return 0, false
 	}
 
-	return &b.buffer[index]
+	return b.buffer[index], true
 }
 
+func (b *Builder) Len() int {
+	return len(b.buffer)
+}
+
+func (b *Builder) Cap() int {
+	return cap(b.buffer)
+}
+
+// String doesn't copy the buffer: bytes that are already written are
+// never changed, the next writes only append after them
 func (b *Builder) String() string {
-	return string(b.buffer)
+	b.shared = true
+	return unsafe.String(unsafe.SliceData(b.buffer), len(b.buffer))
+}
+
+// Reset keeps the buffer for reuse only if no string references it
+func (b *Builder) Reset() {
+	b.addr = nil
+	if b.shared {
+		b.buffer = nil
+		b.shared = false
+	} else {
+		b.buffer = b.buffer[:0]
+	}
+}
+
+const maxPooledCapacity = 64 << 10
+
+var builders = sync.Pool{
+	New: func() any {
+		return new(Builder)
+	},
+}
+
+func GetBuilder() *Builder {
+	return builders.Get().(*Builder)
+}
+
+// PutBuilder returns the builder to the pool, the builder can't be
+// used after that, but the strings it returned stay valid: Reset drops
+// a buffer that is shared with a string instead of reusing it. So the
+// pool saves allocations only for builders whose String wasn't called
+func PutBuilder(b *Builder) {
+	if b.Cap() > maxPooledCapacity {
+		return // don't keep huge buffers alive
+	}
+
+	b.Reset()
+	builders.Put(b)
 }
 
 func main() {
 	builder := NewBuilder()
 	builder.Grow(3)
 
-	builder.Write('a')
-	builder.Write('b')
-	builder.Write('c')
+	_ = builder.WriteByte('a')
+	_ = builder.WriteByte('b')
+	_ = builder.WriteByte('c')
+	_, _ = builder.WriteRune('щ')
+	_, _ = builder.WriteString(" мир")
 
 	fmt.Println(builder.String())
+
+	_ = builder.WriteByte("я"[0]) // the first byte of a multi-byte rune
+	fmt.Println(builder.Validate())
+
+	pooled := GetBuilder()
+	defer PutBuilder(pooled)
+
+	fmt.Fprintf(pooled, "%d + %d = %d", 1, 2, 3)
+	fmt.Println(pooled.String())
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeLettersAndWords(t *testing.T) {
+	result, err := Analyze(strings.NewReader("Привет, мир! Hello мир\n"), Options{TopK: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, result.Words)
+	assert.Equal(t, 3, result.Letters["и"])
+	assert.Equal(t, 1, result.Letters["H"])
+	assert.Equal(t, 2, result.Letters["l"])
+	assert.Equal(t, []Term{{Term: "мир", Count: 2}, {Term: "Hello", Count: 1}}, result.TopWords)
+	assert.Nil(t, result.TopNGrams)
+}
+
+func TestAnalyzeFoldCaseAndStopWords(t *testing.T) {
+	text := "The cat and THE dog\nbird. A cat dog, and a Dog bird."
+	result, err := Analyze(strings.NewReader(text), Options{
+		TopK:      3,
+		NGram:     2,
+		FoldCase:  true,
+		StopWords: []string{"The", "a", "and"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, result.Words)
+	assert.Equal(t, []Term{{Term: "dog", Count: 3}, {Term: "bird", Count: 2}, {Term: "cat", Count: 2}}, result.TopWords)
+	// n-grams don't join words around stop words and don't cross line breaks
+	assert.Equal(t, []Term{{Term: "cat dog", Count: 1}, {Term: "dog bird", Count: 1}}, result.TopNGrams)
+}
+
+func TestAnalyzeNGramsAroundStopWords(t *testing.T) {
+	result, err := Analyze(strings.NewReader("the fox jumps over the lazy dog"), Options{
+		TopK:      10,
+		NGram:     2,
+		StopWords: []string{"the", "over"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Term{{Term: "fox jumps", Count: 1}, {Term: "lazy dog", Count: 1}}, result.TopNGrams)
+}
+
+func TestAnalyzeWorkersAgree(t *testing.T) {
+	words := []string{"alpha", "beta", "gamma", "delta", "эпсилон"}
+	var builder strings.Builder
+	for idx := 0; idx < 50_000; idx++ {
+		builder.WriteString(words[idx*idx%len(words)])
+		if idx%7 == 0 {
+			builder.WriteByte('\n')
+		} else {
+			builder.WriteByte(' ')
+		}
+	}
+
+	options := Options{TopK: 3, NGram: 3, Workers: 1}
+	expected, err := Analyze(strings.NewReader(builder.String()), options)
+	require.NoError(t, err)
+
+	options.Workers = 8
+	actual, err := Analyze(strings.NewReader(builder.String()), options)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, 50_000, actual.Words)
+}
+
+func TestTopTerms(t *testing.T) {
+	terms := map[string]int{"a": 5, "b": 1, "c": 5, "d": 3, "e": 2}
+	assert.Equal(t, []Term{{"a", 5}, {"c", 5}, {"d", 3}}, topTerms(terms, 3))
+	assert.Len(t, topTerms(terms, 10), 5)
+	assert.Nil(t, topTerms(terms, 0))
+}
+
+func TestResultJSON(t *testing.T) {
+	result, err := Analyze(strings.NewReader("ab ab"), Options{TopK: 1})
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	require.NoError(t, result.WriteJSON(&buffer))
+
+	var decoded Result
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, result, decoded)
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, decoded.Letters)
+}
@@ -0,0 +1,103 @@
+package main
+
+// Heap keeps at most limit items, when it's full a new item
+// replaces the smallest one only if it's greater
+type Heap[T any] struct {
+	data  []T
+	limit int
+	less  func(T, T) bool
+}
+
+func NewHeap[T any](limit int, less func(T, T) bool) *Heap[T] {
+	return &Heap[T]{
+		data:  make([]T, 0, limit),
+		limit: limit,
+		less:  less,
+	}
+}
+
+func (h *Heap[T]) Push(item T) {
+	if h.limit <= 0 {
+		return
+	}
+
+	if len(h.data) < h.limit {
+		h.data = append(h.data, item)
+		h.up(len(h.data) - 1)
+		return
+	}
+
+	if h.less(h.data[0], item) {
+		h.data[0] = item
+		h.down(0)
+	}
+}
+
+func (h *Heap[T]) Pop() T {
+	if len(h.data) == 0 {
+		return *new(T)
+	}
+
+	result := h.data[0]
+	last := len(h.data) - 1
+	h.data[0] = h.data[last]
+	h.data = h.data[:last]
+
+	if len(h.data) > 0 {
+		h.down(0)
+	}
+
+	return result
+}
+
+func (h *Heap[T]) Len() int {
+	return len(h.data)
+}
+
+func (h *Heap[T]) up(index int) {
+	for index > 0 {
+		parentIndex := parent(index)
+		if !h.less(h.data[index], h.data[parentIndex]) {
+			break
+		}
+
+		h.data[index], h.data[parentIndex] = h.data[parentIndex], h.data[index]
+		index = parentIndex
+	}
+}
+
+func (h *Heap[T]) down(index int) {
+	size := len(h.data)
+	for {
+		minIndex := index
+		leftIndex := leftChild(index)
+		rightIndex := rightChild(index)
+
+		if leftIndex < size && h.less(h.data[leftIndex], h.data[minIndex]) {
+			minIndex = leftIndex
+		}
+
+		if rightIndex < size && h.less(h.data[rightIndex], h.data[minIndex]) {
+			minIndex = rightIndex
+		}
+
+		if minIndex == index {
+			break
+		}
+
+		h.data[index], h.data[minIndex] = h.data[minIndex], h.data[index]
+		index = minIndex
+	}
+}
+
+func parent(i int) int {
+	return (i - 1) / 2
+}
+
+func leftChild(i int) int {
+	return 2*i + 1
+}
+
+func rightChild(i int) int {
+	return 2*i + 2
+}
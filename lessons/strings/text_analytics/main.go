@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+)
+
+const chunkSize = 64 * 1024
+
+type Options struct {
+	Workers   int // runtime.NumCPU() by default
+	TopK      int
+	NGram     int // size of word n-grams, n-grams aren't counted if it's less than 2
+	FoldCase  bool
+	StopWords []string // excluded from words and n-grams
+}
+
+type Term struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+type Result struct {
+	Letters   map[string]int `json:"letters"`
+	Words     int            `json:"words"`
+	TopWords  []Term         `json:"top_words"`
+	TopNGrams []Term         `json:"top_ngrams,omitempty"`
+}
+
+func (r Result) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type counts struct {
+	letters map[rune]int
+	words   map[string]int
+	ngrams  map[string]int
+}
+
+func newCounts() counts {
+	return counts{
+		letters: make(map[rune]int),
+		words:   make(map[string]int),
+		ngrams:  make(map[string]int),
+	}
+}
+
+func (c counts) merge(other counts) {
+	for letter, count := range other.letters {
+		c.letters[letter] += count
+	}
+
+	for word, count := range other.words {
+		c.words[word] += count
+	}
+
+	for ngram, count := range other.ngrams {
+		c.ngrams[ngram] += count
+	}
+}
+
+// Analyze splits the text into chunks of whole lines, workers count them
+// into their own maps and the maps are merged at the end. N-grams don't
+// cross line breaks, so chunks can be counted independently
+func Analyze(reader io.Reader, options Options) (Result, error) {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+
+	stopWords := make(map[string]struct{}, len(options.StopWords))
+	for _, word := range options.StopWords {
+		if options.FoldCase {
+			word = cases.Fold().String(word)
+		}
+		stopWords[word] = struct{}{}
+	}
+
+	chunks := make(chan []byte, options.Workers)
+	results := make([]counts, options.Workers)
+
+	wg := sync.WaitGroup{}
+	wg.Add(options.Workers)
+	for idx := range results {
+		results[idx] = newCounts()
+		go func(local counts) {
+			defer wg.Done()
+			worker := newWorker(options, stopWords, local)
+			for chunk := range chunks {
+				worker.count(chunk)
+			}
+		}(results[idx])
+	}
+
+	err := split(reader, chunks)
+	close(chunks)
+	wg.Wait()
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	total := results[0]
+	for _, local := range results[1:] {
+		total.merge(local)
+	}
+
+	return newResult(total, options.TopK), nil
+}
+
+func split(reader io.Reader, chunks chan<- []byte) error {
+	buffered := bufio.NewReaderSize(reader, chunkSize)
+	chunk := make([]byte, 0, chunkSize)
+	for {
+		line, err := buffered.ReadSlice('\n')
+		chunk = append(chunk, line...)
+
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			if len(chunk) > 0 {
+				chunks <- chunk
+			}
+
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		// a long line without a break stays in one chunk
+		if len(chunk) >= chunkSize && !errors.Is(err, bufio.ErrBufferFull) {
+			chunks <- chunk
+			chunk = make([]byte, 0, chunkSize)
+		}
+	}
+}
+
+type worker struct {
+	options   Options
+	stopWords map[string]struct{}
+	counts    counts
+	caser     cases.Caser // isn't safe for concurrent use
+	window    []string
+}
+
+func newWorker(options Options, stopWords map[string]struct{}, local counts) *worker {
+	return &worker{
+		options:   options,
+		stopWords: stopWords,
+		counts:    local,
+		caser:     cases.Fold(),
+	}
+}
+
+func (w *worker) count(chunk []byte) {
+	text := string(chunk)
+	if w.options.FoldCase {
+		text = w.caser.String(text)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		w.window = w.window[:0]
+		words := strings.FieldsFunc(line, func(symbol rune) bool {
+			return !unicode.IsLetter(symbol) && !unicode.IsDigit(symbol)
+		})
+
+		for _, word := range words {
+			for _, symbol := range word {
+				if unicode.IsLetter(symbol) {
+					w.counts.letters[symbol]++
+				}
+			}
+
+			if _, found := w.stopWords[word]; found {
+				w.window = w.window[:0] // words around it aren't adjacent
+				continue
+			}
+
+			w.counts.words[word]++
+			w.countNGram(word)
+		}
+	}
+}
+
+func (w *worker) countNGram(word string) {
+	if w.options.NGram < 2 {
+		return
+	}
+
+	w.window = append(w.window, word)
+	if len(w.window) > w.options.NGram {
+		w.window = w.window[1:]
+	}
+
+	if len(w.window) == w.options.NGram {
+		w.counts.ngrams[strings.Join(w.window, " ")]++
+	}
+}
+
+func newResult(total counts, topK int) Result {
+	result := Result{
+		Letters:   make(map[string]int, len(total.letters)),
+		TopWords:  topTerms(total.words, topK),
+		TopNGrams: topTerms(total.ngrams, topK),
+	}
+
+	for letter, count := range total.letters {
+		result.Letters[string(letter)] = count
+	}
+
+	for _, count := range total.words {
+		result.Words += count
+	}
+
+	return result
+}
+
+// topTerms sorts terms by count and then alphabetically
+func topTerms(terms map[string]int, topK int) []Term {
+	less := func(lhs, rhs Term) bool {
+		if lhs.Count != rhs.Count {
+			return lhs.Count < rhs.Count
+		}
+		return lhs.Term > rhs.Term
+	}
+
+	heap := NewHeap[Term](topK, less)
+	for term, count := range terms {
+		heap.Push(Term{Term: term, Count: count})
+	}
+
+	if heap.Len() == 0 {
+		return nil
+	}
+
+	top := make([]Term, heap.Len())
+	for idx := len(top) - 1; idx >= 0; idx-- {
+		top[idx] = heap.Pop()
+	}
+
+	return top
+}
+
+func main() {
+	text := "The quick brown fox jumps over the lazy dog.\nThe quick dog sleeps."
+	result, err := Analyze(strings.NewReader(text), Options{
+		TopK:      3,
+		NGram:     2,
+		FoldCase:  true,
+		StopWords: []string{"the", "over"},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	_ = result.WriteJSON(os.Stdout)
+}
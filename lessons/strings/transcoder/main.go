@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+type Encoding int
+
+const (
+	Auto Encoding = iota // detected by BOM, UTF-8 without BOM
+	UTF8
+	UTF16LE
+	UTF16BE
+	Windows1251
+	KOI8R
+	Latin1
+)
+
+var encodingNames = map[Encoding]string{
+	Auto:        "auto",
+	UTF8:        "UTF-8",
+	UTF16LE:     "UTF-16LE",
+	UTF16BE:     "UTF-16BE",
+	Windows1251: "Windows-1251",
+	KOI8R:       "KOI8-R",
+	Latin1:      "Latin-1",
+}
+
+func (e Encoding) String() string {
+	return encodingNames[e]
+}
+
+var charmaps = map[Encoding]*charmap.Charmap{
+	Windows1251: charmap.Windows1251,
+	KOI8R:       charmap.KOI8R,
+	Latin1:      charmap.ISO8859_1,
+}
+
+var boms = []struct {
+	bom      string
+	encoding Encoding
+}{
+	{"\xEF\xBB\xBF", UTF8},
+	{"\xFF\xFE", UTF16LE},
+	{"\xFE\xFF", UTF16BE},
+}
+
+type DecodeError struct {
+	Encoding Encoding
+	Offset   int64 // offset of the undecodable sequence in the source
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("undecodable %s sequence at offset %d", e.Encoding, e.Offset)
+}
+
+// decoder converts the source to UTF-8 chunk by chunk, incomplete
+// sequences at the end of a chunk wait for the next one
+type decoder struct {
+	encoding Encoding
+	strict   bool
+	detected bool
+	offset   int64
+	pending  []byte
+}
+
+func newDecoder(encoding Encoding, strict bool) decoder {
+	return decoder{encoding: encoding, strict: strict}
+}
+
+func (d *decoder) decode(out, src []byte, atEOF bool) ([]byte, error) {
+	data := src
+	if len(d.pending) > 0 {
+		data = append(d.pending, src...)
+		d.pending = nil
+	}
+
+	if !d.detected {
+		if len(data) < 3 && !atEOF {
+			d.pending = append([]byte(nil), data...)
+			return out, nil
+		}
+
+		skipped := d.detectBOM(data)
+		data = data[skipped:]
+		d.offset += int64(skipped)
+		d.detected = true
+	}
+
+	idx := 0
+	for idx < len(data) {
+		symbol, size, valid := d.decodeRune(data[idx:], atEOF)
+		if size == 0 {
+			d.pending = append([]byte(nil), data[idx:]...)
+			break
+		}
+
+		if !valid {
+			if d.strict {
+				d.offset += int64(idx)
+				return out, &DecodeError{Encoding: d.encoding, Offset: d.offset}
+			}
+
+			symbol = utf8.RuneError
+		}
+
+		out = utf8.AppendRune(out, symbol)
+		idx += size
+	}
+
+	d.offset += int64(idx)
+	return out, nil
+}
+
+func (d *decoder) detectBOM(data []byte) int {
+	for _, bom := range boms {
+		if !strings.HasPrefix(string(data), bom.bom) {
+			continue
+		}
+
+		if d.encoding == Auto || d.encoding == bom.encoding {
+			d.encoding = bom.encoding
+			return len(bom.bom)
+		}
+	}
+
+	if d.encoding == Auto {
+		d.encoding = UTF8
+	}
+
+	return 0
+}
+
+// decodeRune returns zero size when data is an incomplete sequence
+// that can be finished by the next chunk
+func (d *decoder) decodeRune(data []byte, atEOF bool) (rune, int, bool) {
+	switch d.encoding {
+	case UTF16LE, UTF16BE:
+		return d.decodeUTF16(data, atEOF)
+	case UTF8:
+		symbol, size := utf8.DecodeRune(data)
+		if symbol == utf8.RuneError && size <= 1 {
+			if !atEOF && !utf8.FullRune(data) {
+				return 0, 0, false
+			}
+			return utf8.RuneError, 1, false
+		}
+		return symbol, size, true
+	default:
+		symbol := charmaps[d.encoding].DecodeByte(data[0])
+		return symbol, 1, symbol != utf8.RuneError // undefined bytes are decoded to U+FFFD
+	}
+}
+
+func (d *decoder) decodeUTF16(data []byte, atEOF bool) (rune, int, bool) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if d.encoding == UTF16BE {
+		order = binary.BigEndian
+	}
+
+	if len(data) < 2 {
+		if !atEOF {
+			return 0, 0, false
+		}
+		return utf8.RuneError, len(data), false
+	}
+
+	first := rune(order.Uint16(data))
+	if !utf16.IsSurrogate(first) {
+		return first, 2, true
+	}
+
+	if len(data) < 4 {
+		if !atEOF {
+			return 0, 0, false
+		}
+		return utf8.RuneError, 2, false
+	}
+
+	symbol := utf16.DecodeRune(first, rune(order.Uint16(data[2:])))
+	if symbol == utf8.RuneError {
+		return utf8.RuneError, 2, false // unpaired surrogate
+	}
+
+	return symbol, 4, true
+}
+
+type Reader struct {
+	source  io.Reader
+	decoder decoder
+	input   []byte
+	output  []byte
+	read    int
+	err     error
+}
+
+// NewReader decodes the source from the encoding to UTF-8, in strict mode the
+// first undecodable sequence stops reading with *DecodeError, otherwise it's
+// replaced with U+FFFD
+func NewReader(source io.Reader, encoding Encoding, strict bool) *Reader {
+	return &Reader{
+		source:  source,
+		decoder: newDecoder(encoding, strict),
+		input:   make([]byte, 4096),
+	}
+}
+
+func (r *Reader) Read(buffer []byte) (int, error) {
+	for r.read == len(r.output) {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		n, err := r.source.Read(r.input)
+		r.output, r.read = r.output[:0], 0
+
+		var decodeErr error
+		r.output, decodeErr = r.decoder.decode(r.output, r.input[:n], err == io.EOF)
+		if decodeErr != nil {
+			r.err = decodeErr
+		} else if err != nil {
+			r.err = err
+		}
+	}
+
+	n := copy(buffer, r.output[r.read:])
+	r.read += n
+	return n, nil
+}
+
+// Writer decodes everything written to it and writes UTF-8 to the destination,
+// Close must be called to flush an incomplete sequence at the end
+type Writer struct {
+	destination io.Writer
+	decoder     decoder
+	output      []byte
+}
+
+func NewWriter(destination io.Writer, encoding Encoding, strict bool) *Writer {
+	return &Writer{
+		destination: destination,
+		decoder:     newDecoder(encoding, strict),
+	}
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	start := w.decoder.offset + int64(len(w.decoder.pending))
+	output, decodeErr := w.decoder.decode(w.output[:0], data, false)
+	w.output = output
+
+	if _, err := w.destination.Write(output); err != nil {
+		return 0, err
+	}
+
+	if decodeErr != nil {
+		written := max(0, int(w.decoder.offset-start))
+		return written, decodeErr
+	}
+
+	return len(data), nil
+}
+
+func (w *Writer) Close() error {
+	output, decodeErr := w.decoder.decode(w.output[:0], nil, true)
+	if _, err := w.destination.Write(output); err != nil {
+		return err
+	}
+
+	return decodeErr
+}
+
+func main() {
+	feed, _ := charmap.KOI8R.NewEncoder().String("Привет из КОИ-8\n")
+
+	reader := NewReader(strings.NewReader(feed), KOI8R, true)
+	if _, err := io.Copy(os.Stdout, reader); err != nil {
+		fmt.Println(err)
+	}
+}
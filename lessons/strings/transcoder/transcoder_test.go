@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const text = "Съешь же ещё этих мягких французских булок 😀"
+
+func encode(t *testing.T, encoder *encoding.Encoder, text string) string {
+	encoded, err := encoder.String(text)
+	assert.NoError(t, err)
+	return encoded
+}
+
+func TestReaderEncodings(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding Encoding
+		source   string
+		expected string
+	}{
+		{"Windows1251", Windows1251, encode(t, charmap.Windows1251.NewEncoder(), "Привет, мир"), "Привет, мир"},
+		{"KOI8R", KOI8R, encode(t, charmap.KOI8R.NewEncoder(), "Привет, мир"), "Привет, мир"},
+		{"Latin1", Latin1, encode(t, charmap.ISO8859_1.NewEncoder(), "Café déjà vu"), "Café déjà vu"},
+		{"UTF16LE", UTF16LE, encode(t, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder(), text), text},
+		{"UTF16BE", UTF16BE, encode(t, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder(), text), text},
+		{"AutoUTF16LE", Auto, encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder(), text), text},
+		{"AutoUTF16BE", Auto, encode(t, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewEncoder(), text), text},
+		{"AutoUTF8BOM", Auto, "\xEF\xBB\xBF" + text, text},
+		{"AutoUTF8", Auto, text, text},
+		{"ExplicitBOM", UTF16LE, encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder(), text), text},
+		{"Empty", Auto, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// one byte reads split every multi-byte sequence
+			reader := NewReader(iotest.OneByteReader(strings.NewReader(test.source)), test.encoding, true)
+			decoded, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(decoded))
+
+			var buffer bytes.Buffer
+			writer := NewWriter(&buffer, test.encoding, true)
+			for idx := 0; idx < len(test.source); idx++ {
+				n, err := writer.Write([]byte{test.source[idx]})
+				assert.NoError(t, err)
+				assert.Equal(t, 1, n)
+			}
+			assert.NoError(t, writer.Close())
+			assert.Equal(t, test.expected, buffer.String())
+		})
+	}
+}
+
+func TestReaderStrictErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding Encoding
+		source   string
+		offset   int64
+	}{
+		{"Windows1251Undefined", Windows1251, "ab\x98c", 2},
+		{"UTF8Invalid", UTF8, "abc\xffd", 3},
+		{"UTF8Truncated", UTF8, "ab\xD0", 2},
+		{"UTF8AfterBOM", Auto, "\xEF\xBB\xBFab\xff", 5},
+		{"UTF16UnpairedSurrogate", UTF16LE, "a\x00\x00\xD8b\x00", 2},
+		{"UTF16OddLength", UTF16BE, "\x00a\x00", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(iotest.HalfReader(strings.NewReader(test.source)), test.encoding, true)
+			_, err := io.ReadAll(reader)
+
+			var decodeErr *DecodeError
+			if assert.ErrorAs(t, err, &decodeErr) {
+				assert.Equal(t, test.offset, decodeErr.Offset)
+			}
+		})
+	}
+}
+
+func TestReaderLenient(t *testing.T) {
+	reader := NewReader(strings.NewReader("ab\x98c"), Windows1251, false)
+	decoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab�c", string(decoded))
+
+	reader = NewReader(strings.NewReader("a\x00\x00\xD8b\x00"), UTF16LE, false)
+	decoded, err = io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "a�b", string(decoded))
+}
+
+func TestWriterStrictError(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer, UTF8, true)
+
+	n, err := writer.Write([]byte("ok\xD0"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = writer.Write([]byte("!rest"))
+	var decodeErr *DecodeError
+	if assert.ErrorAs(t, err, &decodeErr) {
+		assert.Equal(t, int64(2), decodeErr.Offset)
+	}
+	assert.Zero(t, n)
+	assert.Equal(t, "ok", buffer.String())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCondSignal(t *testing.T) {
+	cond := NewCond(&sync.Mutex{})
+
+	var woken atomic.Int32
+	for i := 0; i < 2; i++ {
+		go func() {
+			cond.L.Lock()
+			defer cond.L.Unlock()
+
+			cond.Wait()
+			woken.Add(1)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cond.Signal()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), woken.Load())
+
+	cond.Broadcast()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), woken.Load())
+}
+
+func TestCondWaitContext(t *testing.T) {
+	cond := NewCond(&sync.Mutex{})
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cond.L.Lock()
+	assert.ErrorIs(t, cond.WaitContext(ctx), context.DeadlineExceeded)
+	assert.False(t, cond.L.(*sync.Mutex).TryLock()) // locked again
+	assert.False(t, cond.WaitTimeout(100*time.Millisecond))
+	cond.L.Unlock()
+
+	assert.Equal(t, goroutines, runtime.NumGoroutine())
+
+	// cancelled waiters don't take signals from the others
+	var woken atomic.Bool
+	go func() {
+		cond.L.Lock()
+		defer cond.L.Unlock()
+
+		woken.Store(cond.WaitTimeout(time.Second))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cond.Signal()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, woken.Load())
+}
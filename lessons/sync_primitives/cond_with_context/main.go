@@ -0,0 +1,93 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cond keeps a channel per waiter instead of the runtime notify list,
+// so a waiter can stop waiting without any helper goroutine
+type Cond struct {
+	L sync.Locker
+
+	mutex   sync.Mutex
+	waiters list.List
+}
+
+func NewCond(locker sync.Locker) *Cond {
+	return &Cond{L: locker}
+}
+
+func (c *Cond) Wait() {
+	_ = c.WaitContext(context.Background())
+}
+
+// WaitContext works like Wait, but returns the error of the context when
+// it's done before a signal, c.L is locked again in both cases
+func (c *Cond) WaitContext(ctx context.Context) error {
+	signal := make(chan struct{})
+
+	c.mutex.Lock()
+	element := c.waiters.PushBack(signal)
+	c.mutex.Unlock()
+
+	c.L.Unlock()
+	defer c.L.Lock()
+
+	select {
+	case <-signal:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	select {
+	case <-signal:
+		return nil // signal came together with cancellation, don't lose it
+	default:
+		c.waiters.Remove(element)
+		return ctx.Err()
+	}
+}
+
+// WaitTimeout returns false if there was no signal during the timeout
+func (c *Cond) WaitTimeout(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return c.WaitContext(ctx) == nil
+}
+
+func (c *Cond) Signal() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element := c.waiters.Front(); element != nil {
+		close(c.waiters.Remove(element).(chan struct{}))
+	}
+}
+
+func (c *Cond) Broadcast() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for element := c.waiters.Front(); element != nil; element = c.waiters.Front() {
+		close(c.waiters.Remove(element).(chan struct{}))
+	}
+}
+
+func main() {
+	cond := NewCond(&sync.Mutex{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cond.L.Lock()
+	fmt.Println(cond.WaitContext(ctx))
+	cond.L.Unlock()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBarrierCyclic(t *testing.T) {
+	var actions atomic.Int32
+	barrier := NewBarrier(3, func() {
+		actions.Add(1)
+	})
+
+	var passed atomic.Int32
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			for generation := 0; generation < 5; generation++ {
+				assert.NoError(t, barrier.Await(context.Background()))
+				passed.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(5), actions.Load())
+	assert.Equal(t, int32(15), passed.Load())
+}
+
+func TestBarrierCancel(t *testing.T) {
+	barrier := NewBarrier(2, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, barrier.Await(ctx), context.DeadlineExceeded)
+
+	// cancelled party doesn't count as arrived
+	var passed atomic.Bool
+	go func() {
+		_ = barrier.Await(context.Background())
+		passed.Store(true)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, passed.Load())
+
+	assert.NoError(t, barrier.Await(context.Background()))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, passed.Load())
+}
+
+func TestBarrierActionPanic(t *testing.T) {
+	var panics atomic.Bool
+	barrier := NewBarrier(2, func() {
+		if !panics.Load() {
+			panics.Store(true)
+			panic("action failed")
+		}
+	})
+
+	released := make(chan error)
+	go func() {
+		released <- barrier.Await(context.Background())
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Panics(t, func() { _ = barrier.Await(context.Background()) })
+	assert.NoError(t, <-released)
+
+	// next generation works as usual
+	go func() {
+		released <- barrier.Await(context.Background())
+	}()
+	assert.NoError(t, barrier.Await(context.Background()))
+	assert.NoError(t, <-released)
+}
+
+func TestBarrierInvalidParties(t *testing.T) {
+	assert.Panics(t, func() { NewBarrier(0, nil) })
+	assert.Panics(t, func() { NewBarrier(-1, nil) })
+}
+
+func TestCountDownLatch(t *testing.T) {
+	latch := NewCountDownLatch(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, latch.Await(ctx), context.DeadlineExceeded)
+
+	for i := 0; i < 3; i++ {
+		go latch.CountDown()
+	}
+
+	assert.NoError(t, latch.Await(context.Background()))
+	assert.Zero(t, latch.Count())
+
+	latch.CountDown()
+	assert.Zero(t, latch.Count())
+}
+
+func TestPhaserDynamicParties(t *testing.T) {
+	phaser := NewPhaser(1) // main party
+
+	var phases [3]atomic.Int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		phaser.Register()
+		go func(phasesNumber int) {
+			defer wg.Done()
+			for phase := 0; phase < phasesNumber; phase++ {
+				phases[phase].Add(1)
+				_, err := phaser.ArriveAndAwait(context.Background())
+				assert.NoError(t, err)
+			}
+			_, err := phaser.ArriveAndDeregister()
+			assert.NoError(t, err)
+		}(i + 1)
+	}
+
+	for phase := 0; phase < 3; phase++ {
+		next, err := phaser.ArriveAndAwait(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, phase+1, next)
+		assert.Equal(t, int32(3-phase), phases[phase].Load())
+	}
+
+	wg.Wait()
+	assert.Equal(t, 1, phaser.Parties())
+}
+
+func TestPhaserCancel(t *testing.T) {
+	phaser := NewPhaser(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	phase, err := phaser.ArriveAndAwait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, phase)
+
+	phaser.Arrive()
+	assert.Zero(t, phaser.Phase()) // cancelled arrival was withdrawn
+
+	phase, err = phaser.ArriveAndAwait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, phase)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = phaser.AwaitAdvance(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPhaserLastPartyLeaves(t *testing.T) {
+	phaser := NewPhaser(1)
+
+	advanced := make(chan int)
+	go func() {
+		phase, err := phaser.AwaitAdvance(context.Background(), 0)
+		assert.NoError(t, err)
+		advanced <- phase
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	phase, err := phaser.ArriveAndDeregister()
+	assert.NoError(t, err)
+	assert.Zero(t, phase)
+	assert.Equal(t, 1, <-advanced)
+	assert.Zero(t, phaser.Parties())
+
+	_, err = phaser.ArriveAndDeregister()
+	assert.ErrorIs(t, err, ErrNoParties)
+	assert.Zero(t, phaser.Parties())
+
+	phaser.Register()
+	phaser.Arrive()
+	assert.Equal(t, 2, phaser.Phase())
+}
+
+func TestPhaserUnregisteredArrival(t *testing.T) {
+	assert.Panics(t, func() { NewPhaser(-1) })
+
+	phaser := NewPhaser(0)
+	assert.Panics(t, func() { phaser.Arrive() })
+	assert.Panics(t, func() { _, _ = phaser.ArriveAndAwait(context.Background()) })
+
+	phase, err := phaser.AwaitAdvance(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrNoParties)
+	assert.Zero(t, phase)
+
+	phaser.Register()
+	assert.Zero(t, phaser.Arrive())
+	assert.Equal(t, 1, phaser.Phase())
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrNoParties = errors.New("phaser has no registered parties")
+
+// waitContext waits on the condition with the locked cond.L
+// until done returns true or the context is cancelled
+func waitContext(ctx context.Context, cond *sync.Cond, done func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		cond.L.Lock()
+		defer cond.L.Unlock()
+		cond.Broadcast()
+	})
+	defer stop()
+
+	for !done() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		cond.Wait()
+	}
+
+	return nil
+}
+
+// Barrier is a cyclic barrier, the last arrived party runs
+// the action and releases the others to the next generation
+type Barrier struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	parties    int
+	arrived    int
+	generation int
+	action     func()
+}
+
+func NewBarrier(parties int, action func()) *Barrier {
+	if parties <= 0 {
+		panic("barrier: non-positive number of parties")
+	}
+
+	b := &Barrier{parties: parties, action: action}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+func (b *Barrier) Await(ctx context.Context) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	generation := b.generation
+	b.arrived++
+	if b.arrived == b.parties {
+		// others are released even if the action panics
+		defer func() {
+			b.arrived = 0
+			b.generation++
+			b.cond.Broadcast()
+		}()
+
+		if b.action != nil {
+			b.action()
+		}
+
+		return nil
+	}
+
+	err := waitContext(ctx, b.cond, func() bool {
+		return generation != b.generation
+	})
+	if err != nil {
+		b.arrived-- // cancelled party leaves the current generation
+	}
+
+	return err
+}
+
+type CountDownLatch struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	count int
+}
+
+func NewCountDownLatch(count int) *CountDownLatch {
+	l := &CountDownLatch{count: count}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+func (l *CountDownLatch) CountDown() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.count == 0 {
+		return
+	}
+
+	l.count--
+	if l.count == 0 {
+		l.cond.Broadcast()
+	}
+}
+
+func (l *CountDownLatch) Count() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.count
+}
+
+func (l *CountDownLatch) Await(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return waitContext(ctx, l.cond, func() bool {
+		return l.count == 0
+	})
+}
+
+// Phaser is a reusable barrier with a dynamic number of parties,
+// the phase advances when all registered parties arrive
+type Phaser struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	parties int
+	arrived int
+	phase   int
+}
+
+// NewPhaser creates a phaser, parties can be zero if
+// they are registered later with Register
+func NewPhaser(parties int) *Phaser {
+	if parties < 0 {
+		panic("phaser: negative number of parties")
+	}
+
+	p := &Phaser{parties: parties}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *Phaser) Register() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.parties++
+	return p.phase
+}
+
+// Arrive marks arrival of the party without waiting for others
+func (p *Phaser) Arrive() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	phase := p.phase
+	p.arrive()
+	return phase
+}
+
+// ArriveAndDeregister removes the party, when the last party leaves
+// the phase advances, so AwaitAdvance waiters don't hang, and the
+// phaser can be used again after Register
+func (p *Phaser) ArriveAndDeregister() (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.parties == 0 {
+		return p.phase, ErrNoParties
+	}
+
+	phase := p.phase
+	p.parties--
+	p.tryAdvance()
+	return phase, nil
+}
+
+func (p *Phaser) ArriveAndAwait(ctx context.Context) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	phase := p.phase
+	p.arrive()
+
+	err := waitContext(ctx, p.cond, func() bool {
+		return phase != p.phase
+	})
+	if err != nil {
+		p.arrived-- // cancelled party withdraws its arrival
+		return phase, err
+	}
+
+	return p.phase, nil
+}
+
+// AwaitAdvance waits until the phaser leaves the phase,
+// without parties nobody can advance it, so it doesn't wait
+func (p *Phaser) AwaitAdvance(ctx context.Context, phase int) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.parties == 0 {
+		return p.phase, ErrNoParties
+	}
+
+	err := waitContext(ctx, p.cond, func() bool {
+		return phase != p.phase
+	})
+
+	return p.phase, err
+}
+
+func (p *Phaser) Phase() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.phase
+}
+
+func (p *Phaser) Parties() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.parties
+}
+
+func (p *Phaser) arrive() {
+	if p.arrived == p.parties {
+		panic("phaser: arrival of an unregistered party")
+	}
+
+	p.arrived++
+	p.tryAdvance()
+}
+
+func (p *Phaser) tryAdvance() {
+	if p.arrived >= p.parties {
+		p.arrived = 0
+		p.phase++
+		p.cond.Broadcast()
+	}
+}
+
+func main() {
+	barrier := NewBarrier(3, func() {
+		fmt.Println("all parties arrived")
+	})
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			_ = barrier.Await(context.Background())
+		}()
+	}
+
+	wg.Wait()
+}
@@ -1,38 +1,125 @@
 package main
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"sync"
 )
 
+var (
+	ErrIncorrectWeight = errors.New("semaphore: incorrect weight")
+	ErrReleaseTooMuch  = errors.New("semaphore: released more than acquired")
+)
+
+type waiter struct {
+	weight int
+	ready  chan struct{}
+}
+
+// Semaphore serves waiters in FIFO order: a big request at the
+// head of the queue is not overtaken by smaller ones behind it
 type Semaphore struct {
-	count     int
-	max       int
-	condition *sync.Cond
+	mutex   sync.Mutex
+	count   int
+	max     int
+	waiters list.List
 }
 
 func NewSemaphore(limit int) *Semaphore {
-	mutex := &sync.Mutex{}
-	return &Semaphore{
-		max:       limit,
-		condition: sync.NewCond(mutex),
+	return &Semaphore{max: limit}
+}
+
+func (s *Semaphore) Acquire(ctx context.Context, weight int) error {
+	if weight < 0 {
+		return ErrIncorrectWeight
+	}
+
+	s.mutex.Lock()
+	if s.max-s.count >= weight && s.waiters.Len() == 0 {
+		s.count += weight
+		s.mutex.Unlock()
+		return nil
 	}
+
+	if weight > s.max {
+		// can never be satisfied, don't block the others
+		s.mutex.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ready := make(chan struct{})
+	element := s.waiters.PushBack(waiter{weight: weight, ready: ready})
+	s.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return nil // acquired right before cancellation
+	default:
+	}
+
+	isFront := s.waiters.Front() == element
+	s.waiters.Remove(element)
+	if isFront {
+		// waiters behind could wait only for this one
+		s.notifyWaiters()
+	}
+
+	return ctx.Err()
+}
+
+func (s *Semaphore) TryAcquire(weight int) bool {
+	if weight < 0 {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.max-s.count < weight || s.waiters.Len() > 0 {
+		return false
+	}
+
+	s.count += weight
+	return true
 }
 
-func (s *Semaphore) Acquire() {
-	s.condition.L.Lock()
-	defer s.condition.L.Unlock()
+func (s *Semaphore) Release(weight int) error {
+	if weight < 0 {
+		return ErrIncorrectWeight
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	for s.count >= s.max {
-		s.condition.Wait()
+	if weight > s.count {
+		return ErrReleaseTooMuch
 	}
 
-	s.count++
+	s.count -= weight
+	s.notifyWaiters()
+	return nil
 }
 
-func (s *Semaphore) Release() {
-	s.condition.L.Lock()
-	defer s.condition.L.Unlock()
+func (s *Semaphore) notifyWaiters() {
+	for element := s.waiters.Front(); element != nil; element = s.waiters.Front() {
+		w := element.Value.(waiter)
+		if s.max-s.count < w.weight {
+			break // keep FIFO order, don't let smaller waiters overtake
+		}
 
-	s.count--
-	s.condition.Signal()
+		s.count += w.weight
+		s.waiters.Remove(element)
+		close(w.ready)
+	}
 }
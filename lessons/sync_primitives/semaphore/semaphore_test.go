@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphoreWeights(t *testing.T) {
+	semaphore := NewSemaphore(10)
+	assert.NoError(t, semaphore.Acquire(context.Background(), 7))
+	assert.True(t, semaphore.TryAcquire(3))
+	assert.False(t, semaphore.TryAcquire(1))
+
+	assert.NoError(t, semaphore.Release(10))
+	assert.ErrorIs(t, semaphore.Release(1), ErrReleaseTooMuch)
+	assert.True(t, semaphore.TryAcquire(10))
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	semaphore := NewSemaphore(10)
+	assert.NoError(t, semaphore.Acquire(context.Background(), 5))
+
+	var bigAcquired atomic.Bool
+	go func() {
+		_ = semaphore.Acquire(context.Background(), 10) // big waiter at the head
+		bigAcquired.Store(true)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, semaphore.TryAcquire(1)) // small request doesn't overtake
+
+	var smallAcquired atomic.Bool
+	go func() {
+		_ = semaphore.Acquire(context.Background(), 1)
+		smallAcquired.Store(true)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, smallAcquired.Load())
+
+	assert.NoError(t, semaphore.Release(5))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, bigAcquired.Load())
+	assert.False(t, smallAcquired.Load())
+
+	assert.NoError(t, semaphore.Release(10))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, smallAcquired.Load())
+}
+
+func TestSemaphoreCancel(t *testing.T) {
+	semaphore := NewSemaphore(10)
+	assert.NoError(t, semaphore.Acquire(context.Background(), 5))
+
+	var smallAcquired atomic.Bool
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := semaphore.Acquire(ctx, 10) // big waiter gives up
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		_ = semaphore.Acquire(context.Background(), 5) // waits behind the big one
+		smallAcquired.Store(true)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, smallAcquired.Load())
+
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, smallAcquired.Load())
+}
+
+func TestSemaphoreImpossibleWeight(t *testing.T) {
+	semaphore := NewSemaphore(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, semaphore.Acquire(ctx, 11), context.DeadlineExceeded)
+	assert.ErrorIs(t, semaphore.Acquire(ctx, -1), ErrIncorrectWeight)
+	assert.True(t, semaphore.TryAcquire(10))
+}
+
+func TestSemaphoreConcurrentWeights(t *testing.T) {
+	semaphore := NewSemaphore(10)
+
+	var inUse, violations atomic.Int32
+	wg := sync.WaitGroup{}
+	wg.Add(50)
+	for i := 0; i < 50; i++ {
+		go func(weight int) {
+			defer wg.Done()
+			_ = semaphore.Acquire(context.Background(), weight)
+			if inUse.Add(int32(weight)) > 10 {
+				violations.Add(1)
+			}
+			time.Sleep(time.Millisecond)
+			inUse.Add(-int32(weight))
+			_ = semaphore.Release(weight)
+		}(i%10 + 1)
+	}
+
+	wg.Wait()
+	assert.Zero(t, violations.Load())
+	assert.True(t, semaphore.TryAcquire(10))
+}
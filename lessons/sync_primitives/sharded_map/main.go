@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+const cacheLineSize = 64
+
+// shard is padded to a cache line, so locking one shard
+// doesn't invalidate the cache line of its neighbour
+type shard[K comparable, V any] struct {
+	mutex sync.RWMutex
+	data  map[K]V
+	_     [cacheLineSize - (unsafe.Sizeof(sync.RWMutex{})+unsafe.Sizeof(map[int]int(nil)))%cacheLineSize]byte
+}
+
+type ShardedMap[K comparable, V any] struct {
+	seed   maphash.Seed
+	mask   uint64
+	shards []shard[K, V]
+}
+
+func NewShardedMap[K comparable, V any](shardsNumber int) *ShardedMap[K, V] {
+	size := 1
+	for size < shardsNumber {
+		size <<= 1
+	}
+
+	shards := make([]shard[K, V], size)
+	for idx := range shards {
+		shards[idx].data = make(map[K]V)
+	}
+
+	return &ShardedMap[K, V]{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(size - 1),
+		shards: shards,
+	}
+}
+
+func (m *ShardedMap[K, V]) Load(key K) (V, bool) {
+	s := m.shard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, found := s.data[key]
+	return value, found
+}
+
+func (m *ShardedMap[K, V]) Store(key K, value V) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data[key] = value
+}
+
+func (m *ShardedMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if actual, found := s.data[key]; found {
+		return actual, true
+	}
+
+	s.data[key] = value
+	return value, false
+}
+
+// Compute atomically replaces the value of the key with the result of action,
+// the key is deleted if action returns false
+func (m *ShardedMap[K, V]) Compute(key K, action func(value V, found bool) (V, bool)) (V, bool) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, found := s.data[key]
+	newValue, keep := action(value, found)
+	if !keep {
+		delete(s.data, key)
+		return newValue, false
+	}
+
+	s.data[key] = newValue
+	return newValue, true
+}
+
+func (m *ShardedMap[K, V]) Delete(key K) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data, key)
+}
+
+// Range locks one shard at a time, so it isn't a consistent snapshot
+// of the whole map and action must not modify the map
+func (m *ShardedMap[K, V]) Range(action func(key K, value V) bool) {
+	for idx := range m.shards {
+		if !m.rangeShard(&m.shards[idx], action) {
+			return
+		}
+	}
+}
+
+func (m *ShardedMap[K, V]) Len() int {
+	length := 0
+	for idx := range m.shards {
+		s := &m.shards[idx]
+		s.mutex.RLock()
+		length += len(s.data)
+		s.mutex.RUnlock()
+	}
+
+	return length
+}
+
+func (m *ShardedMap[K, V]) rangeShard(s *shard[K, V], action func(key K, value V) bool) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for key, value := range s.data {
+		if !action(key, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *ShardedMap[K, V]) shard(key K) *shard[K, V] {
+	return &m.shards[hashKey(m.seed, key)&m.mask]
+}
+
+// hashKey must give equal hashes for keys equal under ==, so -0 and +0
+// floats are hashed the same way. NaN is never equal to itself,
+// so any hash is fine for it
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch key := any(key).(type) {
+	case string:
+		return maphash.String(seed, key)
+	case int:
+		return hashUint64(seed, uint64(key))
+	case int8:
+		return hashUint64(seed, uint64(key))
+	case int16:
+		return hashUint64(seed, uint64(key))
+	case int32:
+		return hashUint64(seed, uint64(key))
+	case int64:
+		return hashUint64(seed, uint64(key))
+	case uint:
+		return hashUint64(seed, uint64(key))
+	case uint8:
+		return hashUint64(seed, uint64(key))
+	case uint16:
+		return hashUint64(seed, uint64(key))
+	case uint32:
+		return hashUint64(seed, uint64(key))
+	case uint64:
+		return hashUint64(seed, key)
+	case uintptr:
+		return hashUint64(seed, uint64(key))
+	case float32:
+		return hashUint64(seed, floatBits(float64(key)))
+	case float64:
+		return hashUint64(seed, floatBits(key))
+	default:
+		// slow path for structs, arrays, pointers and other comparable types
+		var hash maphash.Hash
+		hash.SetSeed(seed)
+		writeValue(&hash, reflect.ValueOf(key))
+		return hash.Sum64()
+	}
+}
+
+func writeValue(hash *maphash.Hash, value reflect.Value) {
+	write := func(bits uint64) {
+		var buffer [8]byte
+		binary.LittleEndian.PutUint64(buffer[:], bits)
+		_, _ = hash.Write(buffer[:])
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		_, _ = hash.WriteString(value.String())
+	case reflect.Bool:
+		if value.Bool() {
+			write(1)
+		} else {
+			write(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		write(uint64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		write(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		write(floatBits(value.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		write(floatBits(real(value.Complex())))
+		write(floatBits(imag(value.Complex())))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		write(uint64(value.Pointer()))
+	case reflect.Array:
+		for idx := 0; idx < value.Len(); idx++ {
+			writeValue(hash, value.Index(idx))
+		}
+	case reflect.Struct:
+		for idx := 0; idx < value.NumField(); idx++ {
+			if value.Type().Field(idx).Name != "_" { // == ignores blank fields
+				writeValue(hash, value.Field(idx))
+			}
+		}
+	case reflect.Interface:
+		if value.IsNil() {
+			write(0)
+		} else {
+			writeValue(hash, value.Elem())
+		}
+	}
+}
+
+func floatBits(value float64) uint64 {
+	if value == 0 {
+		return 0 // -0 == +0
+	}
+
+	return math.Float64bits(value)
+}
+
+func hashUint64(seed maphash.Seed, key uint64) uint64 {
+	var buffer [8]byte
+	binary.LittleEndian.PutUint64(buffer[:], key)
+	return maphash.Bytes(seed, buffer[:])
+}
+
+func main() {
+	counters := NewShardedMap[string, int](16)
+	counters.Store("requests", 1)
+	counters.Compute("requests", func(value int, _ bool) (int, bool) {
+		return value + 1, true
+	})
+
+	fmt.Println(counters.Load("requests"))
+	fmt.Println(unsafe.Sizeof(shard[string, int]{}))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. .
+
+func TestShardedMapOperations(t *testing.T) {
+	m := NewShardedMap[string, int](10)
+	assert.Len(t, m.shards, 16)
+	assert.Equal(t, uintptr(cacheLineSize), unsafe.Sizeof(shard[string, int]{}))
+
+	_, found := m.Load("key")
+	assert.False(t, found)
+
+	m.Store("key", 1)
+	value, found := m.Load("key")
+	assert.True(t, found)
+	assert.Equal(t, 1, value)
+
+	value, loaded := m.LoadOrStore("key", 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, value)
+
+	value, loaded = m.LoadOrStore("other", 3)
+	assert.False(t, loaded)
+	assert.Equal(t, 3, value)
+	assert.Equal(t, 2, m.Len())
+
+	value, kept := m.Compute("key", func(value int, found bool) (int, bool) {
+		return value + 10, found
+	})
+	assert.True(t, kept)
+	assert.Equal(t, 11, value)
+
+	_, kept = m.Compute("other", func(int, bool) (int, bool) {
+		return 0, false
+	})
+	assert.False(t, kept)
+	_, found = m.Load("other")
+	assert.False(t, found)
+
+	m.Delete("key")
+	assert.Zero(t, m.Len())
+}
+
+func TestShardedMapFloatKeys(t *testing.T) {
+	m := NewShardedMap[float64, int](64)
+	m.Store(0.0, 1)
+
+	value, found := m.Load(math.Copysign(0, -1))
+	assert.True(t, found) // -0 == +0
+	assert.Equal(t, 1, value)
+
+	small := NewShardedMap[float32, int](64)
+	small.Store(float32(math.Copysign(0, -1)), 2)
+	value, found = small.Load(0)
+	assert.True(t, found)
+	assert.Equal(t, 2, value)
+}
+
+func TestShardedMapCompositeKeys(t *testing.T) {
+	type key struct {
+		name   string
+		weight float64
+		id     int8
+		parent *int
+	}
+
+	parent := new(int)
+	m := NewShardedMap[key, int](64)
+	m.Store(key{name: "a", weight: 0, id: 1, parent: parent}, 1)
+
+	value, found := m.Load(key{name: "a", weight: math.Copysign(0, -1), id: 1, parent: parent})
+	assert.True(t, found)
+	assert.Equal(t, 1, value)
+
+	_, found = m.Load(key{name: "a", id: 1, parent: new(int)})
+	assert.False(t, found)
+
+	anyKeys := NewShardedMap[any, int](64)
+	anyKeys.Store([2]any{"x", 0.0}, 3)
+	value, found = anyKeys.Load([2]any{"x", math.Copysign(0, -1)})
+	assert.True(t, found)
+	assert.Equal(t, 3, value)
+}
+
+func TestShardedMapBlankFieldKeys(t *testing.T) {
+	type key struct {
+		id int
+		_  int64
+	}
+
+	stored, loaded := key{id: 1}, key{id: 1}
+	*(*int64)(unsafe.Add(unsafe.Pointer(&loaded), unsafe.Offsetof(stored.id)+unsafe.Sizeof(stored.id))) = 42
+	assert.True(t, stored == loaded)
+
+	m := NewShardedMap[key, int](64)
+	m.Store(stored, 1)
+
+	value, found := m.Load(loaded)
+	assert.True(t, found)
+	assert.Equal(t, 1, value)
+}
+
+func TestShardedMapRange(t *testing.T) {
+	m := NewShardedMap[int, int](4)
+	for i := 0; i < 100; i++ {
+		m.Store(i, i*i)
+	}
+
+	visited := 0
+	m.Range(func(key, value int) bool {
+		assert.Equal(t, key*key, value)
+		visited++
+		return true
+	})
+	assert.Equal(t, 100, visited)
+
+	visited = 0
+	m.Range(func(int, int) bool {
+		visited++
+		return visited < 10
+	})
+	assert.Equal(t, 10, visited)
+}
+
+func TestShardedMapConcurrentCompute(t *testing.T) {
+	m := NewShardedMap[string, int](8)
+
+	wg := sync.WaitGroup{}
+	wg.Add(8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Compute(strconv.Itoa(j%10), func(value int, _ bool) (int, bool) {
+					return value + 1, true
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+	m.Range(func(_ string, value int) bool {
+		assert.Equal(t, 800, value)
+		return true
+	})
+}
+
+type concurrentMap interface {
+	Load(string) (int, bool)
+	Store(string, int)
+}
+
+type LockedMap struct {
+	mutex sync.RWMutex
+	data  map[string]int
+}
+
+func (m *LockedMap) Load(key string) (int, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	value, found := m.data[key]
+	return value, found
+}
+
+func (m *LockedMap) Store(key string, value int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.data[key] = value
+}
+
+type SyncMap struct {
+	data sync.Map
+}
+
+func (m *SyncMap) Load(key string) (int, bool) {
+	value, found := m.data.Load(key)
+	if !found {
+		return 0, false
+	}
+
+	return value.(int), true
+}
+
+func (m *SyncMap) Store(key string, value int) {
+	m.data.Store(key, value)
+}
+
+func BenchmarkMaps(b *testing.B) {
+	keys := make([]string, 1024)
+	for idx := range keys {
+		keys[idx] = strconv.Itoa(idx)
+	}
+
+	maps := []struct {
+		name   string
+		newMap func() concurrentMap
+	}{
+		{"Sharded", func() concurrentMap { return NewShardedMap[string, int](runtime.NumCPU() * 4) }},
+		{"SingleLock", func() concurrentMap { return &LockedMap{data: make(map[string]int)} }},
+		{"SyncMap", func() concurrentMap { return &SyncMap{} }},
+	}
+
+	workloads := []struct {
+		name         string
+		writePercent int
+	}{
+		{"ReadHeavy", 10},
+		{"WriteHeavy", 90},
+	}
+
+	for _, workload := range workloads {
+		for _, m := range maps {
+			b.Run(workload.name+"/"+m.name, func(b *testing.B) {
+				data := m.newMap()
+				for idx, key := range keys {
+					data.Store(key, idx)
+				}
+
+				var goroutine atomic.Int32
+				b.SetParallelism(runtime.NumCPU())
+				b.RunParallel(func(pb *testing.PB) {
+					offset := int(goroutine.Add(1)) * 97
+					for i := 0; pb.Next(); i++ {
+						key := keys[(offset+i)%len(keys)]
+						if i%100 < workload.writePercent {
+							data.Store(key, i)
+						} else {
+							data.Load(key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	callers int
+	shared  bool
+	cancel  context.CancelFunc
+}
+
+// Group runs only one action per key at a time, callers
+// with the same key wait for it and share its result
+type Group[K comparable, V any] struct {
+	mutex sync.Mutex
+	calls map[K]*call[V]
+}
+
+// Do returns the result of action and whether it was shared with other
+// callers. A caller stops waiting when its context is done, the action
+// itself is cancelled only when all its callers are gone
+func (g *Group[K, V]) Do(ctx context.Context, key K, action func(context.Context) (V, error)) (V, bool, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+
+	c, found := g.calls[key]
+	if found {
+		c.callers++
+		c.shared = true
+	} else {
+		// action keeps values of the first caller, but not its cancellation
+		actionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), callers: 1, cancel: cancel}
+		g.calls[key] = c
+		go g.run(actionCtx, key, c, action)
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.shared, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// Forget makes the next Do with the key run a new action
+// instead of waiting for the one in flight
+func (g *Group[K, V]) Forget(key K) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.calls, key)
+}
+
+func (g *Group[K, V]) run(ctx context.Context, key K, c *call[V], action func(context.Context) (V, error)) {
+	defer c.cancel()
+	defer close(c.done)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.err = fmt.Errorf("singleflight: panic in action: %v", recovered)
+		}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+	}()
+
+	c.value, c.err = action(ctx)
+}
+
+func (g *Group[K, V]) leave(key K, c *call[V]) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	c.callers--
+	if c.callers == 0 {
+		c.cancel()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+	}
+}
+
+func main() {
+	var group Group[string, string]
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			value, shared, err := group.Do(context.Background(), "config", func(context.Context) (string, error) {
+				time.Sleep(100 * time.Millisecond) // slow cache fill
+				return "value", nil
+			})
+			fmt.Println(value, shared, err)
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupSharesResult(t *testing.T) {
+	var group Group[string, int]
+	var calls atomic.Int32
+
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			value, shared, err := group.Do(context.Background(), "key", func(context.Context) (int, error) {
+				calls.Add(1)
+				time.Sleep(100 * time.Millisecond)
+				return 42, nil
+			})
+
+			assert.NoError(t, err)
+			assert.True(t, shared)
+			assert.Equal(t, 42, value)
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	// finished call isn't reused
+	value, shared, err := group.Do(context.Background(), "key", func(context.Context) (int, error) {
+		return 0, errors.New("error")
+	})
+	assert.Error(t, err)
+	assert.False(t, shared)
+	assert.Zero(t, value)
+}
+
+func TestGroupCallerContext(t *testing.T) {
+	var group Group[string, int]
+	release := make(chan struct{})
+	action := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	result := make(chan error)
+	go func() {
+		_, _, err := group.Do(context.Background(), "key", action)
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := group.Do(ctx, "key", action) // impatient caller leaves alone
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, <-result)
+}
+
+func TestGroupCancelsAbandonedAction(t *testing.T) {
+	var group Group[string, int]
+	cancelled := make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := group.Do(ctx, "key", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("action without callers isn't cancelled")
+	}
+}
+
+func TestGroupForget(t *testing.T) {
+	var group Group[string, int]
+	release := make(chan struct{})
+
+	go func() {
+		_, _, _ = group.Do(context.Background(), "key", func(context.Context) (int, error) {
+			<-release
+			return 1, nil
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	group.Forget("key")
+
+	value, shared, err := group.Do(context.Background(), "key", func(context.Context) (int, error) {
+		return 2, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, 2, value)
+	close(release)
+}
+
+func TestGroupPanic(t *testing.T) {
+	var group Group[string, int]
+	_, _, err := group.Do(context.Background(), "key", func(context.Context) (int, error) {
+		panic("boom")
+	})
+	assert.ErrorContains(t, err, "boom")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. .
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter[int]()
+
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.Inc()
+			}
+			counter.Add(-500)
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 5000, counter.Load())
+
+	counter.Reset()
+	assert.Zero(t, counter.Load())
+}
+
+func TestAccumulators(t *testing.T) {
+	maximum := NewMaxAccumulator[int32]()
+	minimum := NewMinAccumulator[int32]()
+
+	_, ok := maximum.Load()
+	assert.False(t, ok)
+
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func(idx int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				value := int32(idx*100 + j - 500)
+				maximum.Update(value)
+				minimum.Update(value)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	value, ok := maximum.Load()
+	assert.True(t, ok)
+	assert.Equal(t, int32(499), value)
+
+	value, ok = minimum.Load()
+	assert.True(t, ok)
+	assert.Equal(t, int32(-500), value)
+
+	minimum.Reset()
+	_, ok = minimum.Load()
+	assert.False(t, ok)
+}
+
+func TestAccumulatorLoadDuringFirstUpdate(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		maximum := NewMaxAccumulator[int32]()
+		go maximum.Update(7)
+
+		for {
+			// the identity must never leak out as a value
+			if value, ok := maximum.Load(); ok {
+				assert.Equal(t, int32(7), value)
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func BenchmarkAtomicCounter(b *testing.B) {
+	var counter atomic.Int64
+	b.SetParallelism(runtime.NumCPU())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			counter.Add(1)
+		}
+	})
+}
+
+func BenchmarkMutexCounter(b *testing.B) {
+	var mutex sync.Mutex
+	var counter int64
+	b.SetParallelism(runtime.NumCPU())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mutex.Lock()
+			counter++
+			mutex.Unlock()
+		}
+	})
+}
+
+func BenchmarkStripedCounter(b *testing.B) {
+	counter := NewCounter[int64]()
+	b.SetParallelism(runtime.NumCPU())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			counter.Inc()
+		}
+	})
+}
+
+func BenchmarkMaxAccumulator(b *testing.B) {
+	maximum := NewMaxAccumulator[int64]()
+	b.SetParallelism(runtime.NumCPU())
+	b.RunParallel(func(pb *testing.PB) {
+		for i := int64(0); pb.Next(); i++ {
+			maximum.Update(i)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// cell takes the whole cache line, so increments
+// of different cells don't cause false sharing
+type cell struct {
+	value atomic.Int64
+	_     [56]byte
+}
+
+func newCells() []cell {
+	size := 1
+	for size < runtime.GOMAXPROCS(0) {
+		size <<= 1
+	}
+
+	return make([]cell, size)
+}
+
+// pick spreads goroutines over cells, runtime random
+// generator has per-P state and doesn't contend itself
+func pick(cells []cell) *cell {
+	return &cells[rand.Uint32()&uint32(len(cells)-1)]
+}
+
+// Counter spreads increments across cells and sums them up on Load
+type Counter[T Integer] struct {
+	cells []cell
+}
+
+func NewCounter[T Integer]() *Counter[T] {
+	return &Counter[T]{cells: newCells()}
+}
+
+func (c *Counter[T]) Add(delta T) {
+	pick(c.cells).value.Add(int64(delta))
+}
+
+func (c *Counter[T]) Inc() {
+	c.Add(1)
+}
+
+// Load isn't an atomic snapshot: increments that run
+// concurrently with it may be counted or not
+func (c *Counter[T]) Load() T {
+	var sum int64
+	for idx := range c.cells {
+		sum += c.cells[idx].value.Load()
+	}
+
+	return T(sum)
+}
+
+// Reset isn't atomic too, concurrent increments may be lost
+func (c *Counter[T]) Reset() {
+	for idx := range c.cells {
+		c.cells[idx].value.Store(0)
+	}
+}
+
+// Accumulator keeps the maximum or the minimum of the observed values
+type Accumulator[T Integer] struct {
+	cells    []cell
+	identity int64
+	better   func(value, current int64) bool
+	updated  atomic.Bool
+}
+
+func NewMaxAccumulator[T Integer]() *Accumulator[T] {
+	return newAccumulator[T](math.MinInt64, func(value, current int64) bool {
+		return value > current
+	})
+}
+
+func NewMinAccumulator[T Integer]() *Accumulator[T] {
+	return newAccumulator[T](math.MaxInt64, func(value, current int64) bool {
+		return value < current
+	})
+}
+
+func newAccumulator[T Integer](identity int64, better func(value, current int64) bool) *Accumulator[T] {
+	a := &Accumulator[T]{
+		cells:    newCells(),
+		identity: identity,
+		better:   better,
+	}
+
+	a.Reset()
+	return a
+}
+
+// Update marks the accumulator as updated only after the value is
+// published or turned out to be worse, so Load never sees the identity
+func (a *Accumulator[T]) Update(value T) {
+	c := pick(a.cells)
+	for {
+		current := c.value.Load()
+		if !a.better(int64(value), current) || c.value.CompareAndSwap(current, int64(value)) {
+			break
+		}
+	}
+
+	if !a.updated.Load() {
+		a.updated.Store(true)
+	}
+}
+
+func (a *Accumulator[T]) Load() (T, bool) {
+	if !a.updated.Load() {
+		return 0, false
+	}
+
+	result := a.identity
+	for idx := range a.cells {
+		if value := a.cells[idx].value.Load(); a.better(value, result) {
+			result = value
+		}
+	}
+
+	return T(result), true
+}
+
+func (a *Accumulator[T]) Reset() {
+	a.updated.Store(false)
+	for idx := range a.cells {
+		a.cells[idx].value.Store(a.identity)
+	}
+}
+
+func main() {
+	requests := NewCounter[int64]()
+	latency := NewMaxAccumulator[int64]()
+
+	wg := sync.WaitGroup{}
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		go func(idx int) {
+			defer wg.Done()
+			requests.Inc()
+			latency.Update(int64(idx))
+		}(i)
+	}
+
+	wg.Wait()
+	fmt.Println(requests.Load())
+	fmt.Println(latency.Load())
+}
@@ -2,49 +2,105 @@ package main
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
-// Need to show solution
-
-type Stack struct {
+type Stack[T any] struct {
 	mutex sync.Mutex
-	data  []string
+	data  []T
 }
 
-func NewStack() Stack {
-	return Stack{}
+func NewStack[T any]() *Stack[T] {
+	return &Stack[T]{}
 }
 
-func (b Stack) Push(value string) {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
+func (s *Stack[T]) Push(value T) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	b.data = append(b.data, value)
+	s.data = append(s.data, value)
 }
 
-func (b Stack) Pop() {
-	if len(b.data) < 0 {
-		panic("pop: stack is empty")
+func (s *Stack[T]) Pop() (T, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var zero T
+	if len(s.data) == 0 {
+		return zero, false
 	}
 
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
+	value := s.data[len(s.data)-1]
+	s.data[len(s.data)-1] = zero // don't keep popped value alive
+	s.data = s.data[:len(s.data)-1]
+	return value, true
+}
+
+func (s *Stack[T]) Peek() (T, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.data) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return s.data[len(s.data)-1], true
+}
+
+type node[T any] struct {
+	value T
+	next  *node[T]
+}
+
+// LockFreeStack is a Treiber stack. Every Push allocates a new node and nodes
+// are never reused, so while any goroutine still holds a pointer to a node
+// the garbage collector can't give its address to another node - the head
+// can't change from A to B and back to the same A, so CAS is safe against ABA
+type LockFreeStack[T any] struct {
+	head atomic.Pointer[node[T]]
+}
 
-	b.data = b.data[:len(b.data)-1]
+func NewLockFreeStack[T any]() *LockFreeStack[T] {
+	return &LockFreeStack[T]{}
 }
 
-func (b Stack) Top() string {
-	if len(b.data) < 0 {
-		panic("top: stack is empty")
+func (s *LockFreeStack[T]) Push(value T) {
+	newHead := &node[T]{value: value}
+	for {
+		head := s.head.Load()
+		newHead.next = head
+		if s.head.CompareAndSwap(head, newHead) {
+			return
+		}
 	}
+}
 
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
+func (s *LockFreeStack[T]) Pop() (T, bool) {
+	for {
+		head := s.head.Load()
+		if head == nil {
+			var zero T
+			return zero, false
+		}
+
+		if s.head.CompareAndSwap(head, head.next) {
+			return head.value, true
+		}
+	}
+}
+
+func (s *LockFreeStack[T]) Peek() (T, bool) {
+	head := s.head.Load()
+	if head == nil {
+		var zero T
+		return zero, false
+	}
 
-	return b.data[len(b.data)-1]
+	return head.value, true
 }
 
-var stack Stack
+var stack = NewLockFreeStack[string]()
 
 func producer() {
 	for i := 0; i < 1000; i++ {
@@ -54,7 +110,10 @@ func producer() {
 
 func consumer() {
 	for i := 0; i < 10; i++ {
-		_ = stack.Top()
+		if _, ok := stack.Peek(); !ok {
+			return
+		}
+
 		stack.Pop()
 	}
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -bench=. -cpu=1,4,8 .
+
+type concurrentStack interface {
+	Push(int)
+	Pop() (int, bool)
+	Peek() (int, bool)
+}
+
+func stacks() map[string]func() concurrentStack {
+	return map[string]func() concurrentStack{
+		"Mutex":    func() concurrentStack { return NewStack[int]() },
+		"LockFree": func() concurrentStack { return NewLockFreeStack[int]() },
+	}
+}
+
+func TestStackOrder(t *testing.T) {
+	for name, newStack := range stacks() {
+		t.Run(name, func(t *testing.T) {
+			stack := newStack()
+			_, ok := stack.Pop()
+			assert.False(t, ok)
+			_, ok = stack.Peek()
+			assert.False(t, ok)
+
+			stack.Push(1)
+			stack.Push(2)
+
+			value, ok := stack.Peek()
+			assert.True(t, ok)
+			assert.Equal(t, 2, value)
+
+			value, _ = stack.Pop()
+			assert.Equal(t, 2, value)
+			value, _ = stack.Pop()
+			assert.Equal(t, 1, value)
+
+			_, ok = stack.Pop()
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestStackConcurrentPushPop(t *testing.T) {
+	const goroutines = 8
+	const values = 10000
+
+	for name, newStack := range stacks() {
+		t.Run(name, func(t *testing.T) {
+			stack := newStack()
+
+			popped := make([][]int, goroutines)
+			wg := sync.WaitGroup{}
+			wg.Add(goroutines)
+			for i := 0; i < goroutines; i++ {
+				go func(idx int) {
+					defer wg.Done()
+					for j := 0; j < values; j++ {
+						stack.Push(idx*values + j)
+						if value, ok := stack.Pop(); ok {
+							popped[idx] = append(popped[idx], value)
+						}
+					}
+				}(i)
+			}
+
+			wg.Wait()
+			for value, ok := stack.Pop(); ok; value, ok = stack.Pop() {
+				popped[0] = append(popped[0], value)
+			}
+
+			// every value is popped exactly once
+			seen := make(map[int]struct{}, goroutines*values)
+			for _, values := range popped {
+				for _, value := range values {
+					seen[value] = struct{}{}
+				}
+			}
+
+			total := 0
+			for _, values := range popped {
+				total += len(values)
+			}
+
+			assert.Equal(t, goroutines*values, total)
+			assert.Len(t, seen, goroutines*values)
+		})
+	}
+}
+
+func BenchmarkStack(b *testing.B) {
+	for name, newStack := range stacks() {
+		b.Run(name, func(b *testing.B) {
+			stack := newStack()
+			b.SetParallelism(runtime.NumCPU())
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%2 == 0 {
+						stack.Push(i)
+					} else {
+						stack.Pop()
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WaitGroup closes the done channel when the counter reaches zero,
+// so waiting can be combined with a context in select
+type WaitGroup struct {
+	mutex sync.Mutex
+	count int
+	done  chan struct{}
+}
+
+func (wg *WaitGroup) Add(delta int) {
+	wg.mutex.Lock()
+	defer wg.mutex.Unlock()
+
+	if wg.count+delta < 0 {
+		panic("sync: negative WaitGroup counter")
+	}
+
+	if wg.count == 0 && delta > 0 {
+		wg.done = make(chan struct{})
+	}
+
+	wg.count += delta
+	if wg.count == 0 && wg.done != nil {
+		close(wg.done)
+		wg.done = nil
+	}
+}
+
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+func (wg *WaitGroup) Count() int {
+	wg.mutex.Lock()
+	defer wg.mutex.Unlock()
+
+	return wg.count
+}
+
+func (wg *WaitGroup) Wait() {
+	_ = wg.WaitContext(context.Background())
+}
+
+func (wg *WaitGroup) WaitContext(ctx context.Context) error {
+	wg.mutex.Lock()
+	done := wg.done
+	wg.mutex.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func main() {
+	var wg WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+	}()
+
+	go func() {
+		defer wg.Done()
+		select {} // stuck worker
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fmt.Println(wg.WaitContext(ctx), wg.Count())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitGroup(t *testing.T) {
+	var wg WaitGroup
+	wg.Wait() // zero counter doesn't block
+
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			time.Sleep(100 * time.Millisecond)
+		}()
+	}
+
+	assert.Equal(t, 3, wg.Count())
+	wg.Wait()
+	assert.Zero(t, wg.Count())
+
+	// reusable after the counter reached zero
+	wg.Add(1)
+	go wg.Done()
+	assert.NoError(t, wg.WaitContext(context.Background()))
+}
+
+func TestWaitGroupWaitContext(t *testing.T) {
+	var wg WaitGroup
+	wg.Add(2)
+	go wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, wg.WaitContext(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 1, wg.Count())
+
+	wg.Done()
+	assert.NoError(t, wg.WaitContext(context.Background()))
+}
+
+func TestWaitGroupNegativeCounter(t *testing.T) {
+	var wg WaitGroup
+	assert.Panics(t, func() {
+		wg.Add(-1)
+	})
+}